@@ -0,0 +1,42 @@
+package gin_helper
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/fox-one/gin-contrib/errors"
+	"github.com/gin-gonic/gin"
+)
+
+var unavailable = errors.New(3, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+
+// HealthChecker implemented by *session.Session
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) (healthy bool, report interface{})
+}
+
+// Healthz liveness, ok as long as the process serves requests
+func Healthz() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		Data(c, "healthy", true)
+	}
+}
+
+// Readyz readiness, 503 with the report if any checker is unhealthy
+func Readyz(checker HealthChecker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		healthy, report := checker.HealthCheck(c.Request.Context())
+		if !healthy {
+			FailErrorWithData(c, unavailable, report)
+			return
+		}
+
+		Data(c, report)
+	}
+}
+
+// RegisterHealth register GET /healthz & /readyz
+func RegisterHealth(r gin.IRoutes, checker HealthChecker) {
+	r.GET("/healthz", Healthz())
+	r.GET("/readyz", Readyz(checker))
+}
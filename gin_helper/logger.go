@@ -2,38 +2,134 @@ package gin_helper
 
 import (
 	"fmt"
+	"math/rand"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	log "github.com/sirupsen/logrus"
 )
 
+// LogConfig access log options, zero value logs like Log(time.Local)
+type LogConfig struct {
+	Location *time.Location
+
+	// RequestIDHeader log header value as "rid" if the request id isn't set
+	// by the RequestID middleware
+	RequestIDHeader string
+	// Route log the route template as "route"
+	Route bool
+	// Size log request & response body size as "in" & "out"
+	Size bool
+	// UserIDKey log c.Get(UserIDKey) as "uid"
+	UserIDKey string
+	// Headers log request headers, the key is the lowercase header name
+	Headers []string
+
+	// SkipPaths exact paths not logged, eg /healthz
+	SkipPaths []string
+	// Skip not log the request if returns true
+	Skip func(c *gin.Context) bool
+
+	// SampleRates fraction (0~1) of requests logged per status,
+	// statuses not in the map are always logged
+	SampleRates map[int]float64
+
+	// Level map status to log level, default is DefaultLogLevel
+	Level func(status int) log.Level
+}
+
+// DefaultLogLevel 2xx & 3xx info, 4xx warn, 5xx error
+func DefaultLogLevel(status int) log.Level {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return log.ErrorLevel
+	case status >= http.StatusBadRequest:
+		return log.WarnLevel
+	default:
+		return log.InfoLevel
+	}
+}
+
 func Log(in *time.Location) gin.HandlerFunc {
+	return LogWithConfig(LogConfig{Location: in})
+}
+
+func LogWithConfig(cfg LogConfig) gin.HandlerFunc {
+	in := cfg.Location
+	if in == nil {
+		in = time.Local
+	}
+
+	level := cfg.Level
+	if level == nil {
+		level = DefaultLogLevel
+	}
+
+	skipPaths := make(map[string]bool, len(cfg.SkipPaths))
+	for _, path := range cfg.SkipPaths {
+		skipPaths[path] = true
+	}
+
 	return func(c *gin.Context) {
 		start := time.Now().In(in)
+		path := c.Request.URL.Path
 		c.Next()
 
-		end := time.Now()
+		if skipPaths[path] || (cfg.Skip != nil && cfg.Skip(c)) {
+			return
+		}
+
 		status := c.Writer.Status()
+		if rate, ok := cfg.SampleRates[status]; ok && rand.Float64() >= rate {
+			return
+		}
+
+		end := time.Now()
 		method := c.Request.Method
 		uri := c.Request.URL.String()
 
 		content := fmt.Sprintf("[%d] %-4s %s", status, method, uri)
 
-		entry := log.WithFields(log.Fields{
+		fields := log.Fields{
 			"ts": start.Format(time.RFC3339),
 			"lt": end.Sub(start),
 			"ip": c.ClientIP(),
 			"ua": c.Request.UserAgent(),
-		})
+		}
+
+		if id := ExtractRequestID(c); id != "" {
+			fields["rid"] = id
+		} else if cfg.RequestIDHeader != "" {
+			fields["rid"] = c.GetHeader(cfg.RequestIDHeader)
+		}
 
-		if status >= http.StatusOK && status < 300 {
-			entry.Info(content)
-		} else if status == http.StatusNotFound {
-			entry.Warn(content)
-		} else {
-			entry.Error(content)
+		if tp, ok := ExtractTraceParent(c); ok {
+			fields["trace"] = tp.TraceID
 		}
+
+		if cfg.Route {
+			fields["route"] = c.FullPath()
+		}
+
+		if cfg.Size {
+			fields["in"] = c.Request.ContentLength
+			fields["out"] = c.Writer.Size()
+		}
+
+		if cfg.UserIDKey != "" {
+			if uid, ok := c.Get(cfg.UserIDKey); ok {
+				fields["uid"] = uid
+			}
+		}
+
+		for _, h := range cfg.Headers {
+			if v := c.GetHeader(h); v != "" {
+				fields[strings.ToLower(h)] = v
+			}
+		}
+
+		log.WithFields(fields).Log(level(status), content)
 	}
 }
@@ -0,0 +1,99 @@
+package gin_helper
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func newLogRouter(cfg LogConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(LogWithConfig(cfg))
+	r.GET("/status/:code", func(c *gin.Context) {
+		code := map[string]int{"200": 200, "302": 302, "404": 404, "500": 500, "503": 503}[c.Param("code")]
+		c.Status(code)
+	})
+	return r
+}
+
+func serve(r http.Handler, path string) {
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+}
+
+func TestDefaultLogLevel(t *testing.T) {
+	for status, level := range map[int]log.Level{
+		200: log.InfoLevel,
+		302: log.InfoLevel,
+		400: log.WarnLevel,
+		404: log.WarnLevel,
+		499: log.WarnLevel,
+		500: log.ErrorLevel,
+		503: log.ErrorLevel,
+	} {
+		assert.Equal(t, level, DefaultLogLevel(status), status)
+	}
+}
+
+func TestLogWithConfig(t *testing.T) {
+	hook := test.NewGlobal()
+	defer hook.Reset()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	r := newLogRouter(LogConfig{
+		SkipPaths: []string{"/status/302"},
+		Skip: func(c *gin.Context) bool {
+			return c.GetHeader("X-Skip") != ""
+		},
+		SampleRates: map[int]float64{200: 0, 503: 1},
+		Route:       true,
+	})
+
+	for _, tc := range []struct {
+		path  string
+		skip  bool
+		level log.Level
+		// logged false if skipped or sampled out
+		logged bool
+	}{
+		{path: "/status/200"},
+		{path: "/status/302"},
+		{path: "/status/404", skip: true},
+		{path: "/status/404", level: log.WarnLevel, logged: true},
+		{path: "/status/500", level: log.ErrorLevel, logged: true},
+		{path: "/status/503", level: log.ErrorLevel, logged: true},
+	} {
+		hook.Reset()
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.skip {
+			req.Header.Set("X-Skip", "1")
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		entry := hook.LastEntry()
+		if !tc.logged {
+			assert.Nil(t, entry, tc.path)
+			continue
+		}
+
+		if assert.NotNil(t, entry, tc.path) {
+			assert.Equal(t, tc.level, entry.Level, tc.path)
+			assert.Equal(t, "/status/:code", entry.Data["route"], tc.path)
+		}
+	}
+
+	// a custom level mapping
+	hook.Reset()
+	serve(newLogRouter(LogConfig{Level: func(int) log.Level { return log.WarnLevel }}), "/status/200")
+	if entry := hook.LastEntry(); assert.NotNil(t, entry) {
+		assert.Equal(t, log.WarnLevel, entry.Level)
+	}
+}
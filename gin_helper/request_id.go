@@ -0,0 +1,153 @@
+package gin_helper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/gofrs/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	RequestIDHeader   = "X-Request-ID"
+	TraceParentHeader = "traceparent"
+
+	requestIDContextKey   = "_gin_helper_request_id"
+	traceParentContextKey = "_gin_helper_trace_parent"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	traceParentKey
+)
+
+// TraceParent W3C trace context, see https://www.w3.org/TR/trace-context/
+type TraceParent struct {
+	TraceID  string
+	ParentID string
+	Flags    string
+}
+
+func (p TraceParent) String() string {
+	return "00-" + p.TraceID + "-" + p.ParentID + "-" + p.Flags
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// ParseTraceParent parse traceparent header
+func ParseTraceParent(v string) (TraceParent, bool) {
+	segments := strings.Split(strings.TrimSpace(v), "-")
+	if len(segments) < 4 || len(segments[0]) != 2 || segments[0] == "ff" {
+		return TraceParent{}, false
+	}
+
+	if segments[0] == "00" && len(segments) != 4 {
+		return TraceParent{}, false
+	}
+
+	p := TraceParent{TraceID: segments[1], ParentID: segments[2], Flags: segments[3]}
+	if !isHex(p.TraceID, 32) || !isHex(p.ParentID, 16) || len(p.Flags) != 2 {
+		return TraceParent{}, false
+	}
+
+	if _, err := hex.DecodeString(p.Flags); err != nil {
+		return TraceParent{}, false
+	}
+
+	return p, true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// traceParentOf trace parent of an otel span
+func traceParentOf(sc trace.SpanContext) TraceParent {
+	return TraceParent{
+		TraceID:  sc.TraceID().String(),
+		ParentID: sc.SpanID().String(),
+		Flags:    sc.TraceFlags().String(),
+	}
+}
+
+// setTraceParent store tp in the gin context & c.Request.Context(), and echo it
+func setTraceParent(c *gin.Context, tp TraceParent) {
+	c.Set(traceParentContextKey, tp)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), traceParentKey, tp))
+	c.Header(TraceParentHeader, tp.String())
+}
+
+// RequestID reuse or create X-Request-ID & traceparent, then store them in
+// the gin context and in c.Request.Context(), so that
+// session.WithContext(c.Request.Context()) carries them downstream.
+// Both are echoed in the response headers. The traceparent is the one of the
+// span started by Trace, whichever runs first
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = uuid.Must(uuid.NewV4()).String()
+		}
+
+		c.Set(requestIDContextKey, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey, id))
+		c.Header(RequestIDHeader, id)
+
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			setTraceParent(c, traceParentOf(sc))
+			return
+		}
+
+		// this hop gets a new parent id, the trace id is kept
+		tp, ok := ParseTraceParent(c.GetHeader(TraceParentHeader))
+		if !ok {
+			tp = TraceParent{TraceID: randomHex(16), Flags: "01"}
+		}
+		tp.ParentID = randomHex(8)
+		setTraceParent(c, tp)
+	}
+}
+
+// ExtractRequestID request id set by RequestID
+func ExtractRequestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// ExtractTraceParent trace parent set by RequestID
+func ExtractTraceParent(c *gin.Context) (TraceParent, bool) {
+	if v, ok := c.Get(traceParentContextKey); ok {
+		tp, ok := v.(TraceParent)
+		return tp, ok
+	}
+
+	return TraceParent{}, false
+}
+
+// RequestIDFromContext request id carried by ctx
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// TraceParentFromContext trace parent carried by ctx
+func TraceParentFromContext(ctx context.Context) (TraceParent, bool) {
+	tp, ok := ctx.Value(traceParentKey).(TraceParent)
+	return tp, ok
+}
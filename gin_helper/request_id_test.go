@@ -0,0 +1,96 @@
+package gin_helper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+
+	for _, tc := range []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"valid", "00-" + traceID + "-" + parentID + "-01", true},
+		{"spaces", " 00-" + traceID + "-" + parentID + "-00 ", true},
+		{"future version", "01-" + traceID + "-" + parentID + "-01-extra", true},
+		{"version 00 with extra", "00-" + traceID + "-" + parentID + "-01-extra", false},
+		{"invalid version", "ff-" + traceID + "-" + parentID + "-01", false},
+		{"long version", "000-" + traceID + "-" + parentID + "-01", false},
+		{"zero trace id", "00-" + strings.Repeat("0", 32) + "-" + parentID + "-01", false},
+		{"zero parent id", "00-" + traceID + "-" + strings.Repeat("0", 16) + "-01", false},
+		{"short trace id", "00-" + traceID[1:] + "-" + parentID + "-01", false},
+		{"short parent id", "00-" + traceID + "-" + parentID[1:] + "-01", false},
+		{"upper case", "00-" + strings.ToUpper(traceID) + "-" + parentID + "-01", false},
+		{"bad flags", "00-" + traceID + "-" + parentID + "-zz", false},
+		{"long flags", "00-" + traceID + "-" + parentID + "-001", false},
+		{"missing segments", "00-" + traceID, false},
+		{"empty", "", false},
+	} {
+		tp, ok := ParseTraceParent(tc.value)
+		assert.Equal(t, tc.ok, ok, tc.name)
+		if ok {
+			assert.Equal(t, traceID, tp.TraceID, tc.name)
+			assert.Equal(t, parentID, tp.ParentID, tc.name)
+		}
+	}
+
+	tp := TraceParent{TraceID: traceID, ParentID: parentID, Flags: "01"}
+	assert.Equal(t, "00-"+traceID+"-"+parentID+"-01", tp.String())
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var (
+		id, ctxID string
+		tp, ctxTP TraceParent
+	)
+	r := gin.New()
+	r.Use(RequestID())
+	r.GET("/", func(c *gin.Context) {
+		id = ExtractRequestID(c)
+		tp, _ = ExtractTraceParent(c)
+		ctxID = RequestIDFromContext(c.Request.Context())
+		ctxTP, _ = TraceParentFromContext(c.Request.Context())
+	})
+
+	// incoming ids are kept, this hop gets a new parent id
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	req.Header.Set(TraceParentHeader, incoming)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, "req-1", id)
+	assert.Equal(t, id, ctxID)
+	assert.Equal(t, tp, ctxTP)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", tp.TraceID)
+	assert.NotEqual(t, "00f067aa0ba902b7", tp.ParentID)
+	assert.Equal(t, "req-1", w.Header().Get(RequestIDHeader))
+	assert.Equal(t, tp.String(), w.Header().Get(TraceParentHeader))
+
+	// missing or bad ones are created
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, strings.Repeat("x", 129))
+	req.Header.Set(TraceParentHeader, "bad")
+	r.ServeHTTP(w, req)
+
+	assert.Len(t, id, 36)
+	assert.Equal(t, id, w.Header().Get(RequestIDHeader))
+	_, ok := ParseTraceParent(w.Header().Get(TraceParentHeader))
+	assert.True(t, ok)
+	assert.Equal(t, "01", tp.Flags)
+}
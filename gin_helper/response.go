@@ -97,6 +97,19 @@ func Fail(c *gin.Context, status, code int, msg string, data interface{}, hints
 		}
 	}
 
+	if id := ExtractRequestID(c); id != "" && IsDebug() {
+		hint := "request_id: " + id
+		if tp, ok := ExtractTraceParent(c); ok {
+			hint += ", trace_id: " + tp.TraceID
+		}
+
+		if v, ok := resp["hint"]; ok {
+			hint = v.(string) + " (" + hint + ")"
+		}
+
+		resp["hint"] = hint
+	}
+
 	Response(c, status, resp)
 }
 
@@ -133,16 +146,66 @@ func FailErrorWithData(c *gin.Context, err error, data interface{}) {
 }
 
 // pagination
-func OkWithPagination(c *gin.Context, cursor string, args ...interface{}) {
+
+// Pagination typed cursors, implemented by pagination.Page
+type Pagination interface {
+	NextCursor() string
+	PrevCursor() string
+}
+
+// OkWithPagination cursor 可以是 string、Pagination 或 fmt.Stringer (*pagination.Cursor)
+func OkWithPagination(c *gin.Context, cursor interface{}, args ...interface{}) {
 	resp := make(gin.H, len(args)/2+1)
 	for idx := 0; idx+1 < len(args); idx += 2 {
 		k, v := args[idx].(string), args[idx+1]
 		resp[k] = v
 	}
 
+	var next, prev string
+	switch v := cursor.(type) {
+	case nil:
+	case string:
+		next = v
+	case Pagination:
+		next, prev = v.NextCursor(), v.PrevCursor()
+	case fmt.Stringer:
+		next = v.String()
+	default:
+		log.Panicln("unsupported cursor", v)
+	}
+
+	pagination := map[string]interface{}{
+		"next_cursor": next,
+		"has_next":    len(next) > 0,
+	}
+
+	if len(prev) > 0 {
+		pagination["prev_cursor"] = prev
+		pagination["has_prev"] = true
+	}
+
+	resp["pagination"] = pagination
+	Response(c, http.StatusOK, resp)
+}
+
+// OkWithPage page-number pagination, page starts from 1
+func OkWithPage(c *gin.Context, page, pageSize, total int, args ...interface{}) {
+	resp := make(gin.H, len(args)/2+1)
+	for idx := 0; idx+1 < len(args); idx += 2 {
+		k, v := args[idx].(string), args[idx+1]
+		resp[k] = v
+	}
+
+	totalPages := 0
+	if pageSize > 0 {
+		totalPages = (total + pageSize - 1) / pageSize
+	}
+
 	resp["pagination"] = map[string]interface{}{
-		"next_cursor": cursor,
-		"has_next":    len(cursor) > 0,
+		"total":       total,
+		"page":        page,
+		"page_size":   pageSize,
+		"total_pages": totalPages,
 	}
 
 	Response(c, http.StatusOK, resp)
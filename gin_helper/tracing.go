@@ -0,0 +1,67 @@
+package gin_helper
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/fox-one/gin-contrib/gin_helper"
+
+// Trace start a server span per request, the span is carried by
+// c.Request.Context() so session.WithContext(c.Request.Context()) parents
+// database & redis spans under it. The traceparent of RequestID, if it ran
+// before, is replaced by the one of the span. The service name belongs to the
+// resource of the tracer provider, see tracing.Config.ServiceName
+func Trace() gin.HandlerFunc {
+	tracer := otel.Tracer(tracerName)
+
+	return func(c *gin.Context) {
+		ctx := otel.GetTextMapPropagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		route := c.FullPath()
+		name := route
+		if name == "" {
+			name = fmt.Sprintf("HTTP %s", c.Request.Method)
+		}
+
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("http.target", c.Request.URL.Path),
+				attribute.String("http.user_agent", c.Request.UserAgent()),
+				attribute.String("net.peer.ip", c.ClientIP()),
+			),
+		)
+		defer span.End()
+
+		if id := ExtractRequestID(c); id != "" {
+			span.SetAttributes(attribute.String("http.request_id", id))
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		if _, ok := ExtractTraceParent(c); ok && span.SpanContext().IsValid() {
+			setTraceParent(c, traceParentOf(span.SpanContext()))
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.status_code", status))
+		if len(c.Errors) > 0 {
+			span.RecordError(c.Errors.Last())
+		}
+
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
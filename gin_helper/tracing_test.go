@@ -0,0 +1,70 @@
+package gin_helper
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTrace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sr := tracetest.NewSpanRecorder()
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	}()
+
+	var tp TraceParent
+	r := gin.New()
+	r.Use(RequestID(), Trace())
+	r.GET("/users/:id", func(c *gin.Context) {
+		tp, _ = ExtractTraceParent(c)
+		if c.Param("id") == "0" {
+			c.Status(http.StatusInternalServerError)
+		}
+	})
+
+	const incoming = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set(TraceParentHeader, incoming)
+	r.ServeHTTP(w, req)
+
+	spans := sr.Ended()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "/users/:id", span.Name())
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+		assert.Equal(t, codes.Unset, span.Status().Code)
+
+		// the traceparent of RequestID follows the span
+		assert.Equal(t, span.SpanContext().TraceID().String(), tp.TraceID)
+		assert.Equal(t, span.SpanContext().SpanID().String(), tp.ParentID)
+		assert.Equal(t, tp.String(), w.Header().Get(TraceParentHeader))
+	}
+
+	// without an incoming traceparent both still agree
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/0", nil))
+
+	spans = sr.Ended()
+	if assert.Len(t, spans, 2) {
+		span := spans[1]
+		assert.Equal(t, span.SpanContext().TraceID().String(), tp.TraceID)
+		assert.Equal(t, tp.String(), w.Header().Get(TraceParentHeader))
+		assert.Equal(t, codes.Error, span.Status().Code)
+	}
+}
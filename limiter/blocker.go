@@ -8,6 +8,8 @@ import (
 
 	"github.com/go-redis/redis"
 	uuid "github.com/gofrs/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Blocker interface {
@@ -16,41 +18,76 @@ type Blocker interface {
 	Clean(id string) error
 }
 
+// ContextBlocker Blocker whose calls are traced as child spans of ctx, the
+// blockers returned by NewBlocker implement it
+type ContextBlocker interface {
+	Blocker
+
+	BlockUntilContext(ctx context.Context, id, cause string, exp time.Time) error
+	StateContext(ctx context.Context, id string) (exp time.Time, cause string, blocked bool)
+	CleanContext(ctx context.Context, id string) error
+}
+
 type sortedSetBlocker struct {
-	client *redis.Client
+	client redis.UniversalClient
 	maxAge time.Duration
 }
 
-func NewBlocker(c *redis.Client, maxAge time.Duration) Blocker {
+// NewBlocker c can be a single node, sentinel (failover) or cluster client
+func NewBlocker(c redis.UniversalClient, maxAge time.Duration) Blocker {
 	return &sortedSetBlocker{c, maxAge}
 }
 
 func (b *sortedSetBlocker) key(id string) string {
-	return "limiter:blocker:" + id
+	// hash tag keeps every key of id in one cluster slot
+	return "limiter:blocker:{" + id + "}"
 }
 
 func (b *sortedSetBlocker) BlockUntil(id, cause string, exp time.Time) error {
+	return b.BlockUntilContext(context.Background(), id, cause, exp)
+}
+
+func (b *sortedSetBlocker) BlockUntilContext(ctx context.Context, id, cause string, exp time.Time) (err error) {
+	ctx, span := tracer().Start(ctx, "limiter.blocker.block", trace.WithAttributes(
+		attribute.String("limiter.block_cause", cause),
+		attribute.String("limiter.block_until", exp.Format(time.RFC3339)),
+	))
+	defer func() { endSpan(span, err) }()
+
 	score := exp.Unix()
 	if score <= time.Now().Unix() {
 		return nil
 	}
 
 	key := b.key(id)
-	_, err := b.client.Pipelined(context.Background(), func(p redis.Pipeliner) error {
-		p.ZRemRangeByScore(context.Background(), key, "-inf", strconv.FormatInt(score, 10))
-		p.ZAdd(context.Background(), key, redis.Z{
+	_, err = b.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(score, 10))
+		p.ZAdd(ctx, key, redis.Z{
 			Member: uuid.Must(uuid.NewV4()).String() + ":" + cause,
 			Score:  float64(score),
 		})
-		p.Expire(context.Background(), key, b.maxAge)
+		p.Expire(ctx, key, b.maxAge)
 		return nil
 	})
 	return err
 }
 
 func (b *sortedSetBlocker) State(id string) (exp time.Time, cause string, blocked bool) {
+	return b.StateContext(context.Background(), id)
+}
+
+func (b *sortedSetBlocker) StateContext(ctx context.Context, id string) (exp time.Time, cause string, blocked bool) {
+	ctx, span := tracer().Start(ctx, "limiter.blocker.state")
+	defer func() {
+		span.SetAttributes(
+			attribute.Bool("limiter.blocked", blocked),
+			attribute.String("limiter.block_cause", cause),
+		)
+		span.End()
+	}()
+
 	key := b.key(id)
-	if val := b.client.ZRangeWithScores(context.Background(), key, -1, -1).Val(); len(val) >= 1 {
+	if val := b.client.ZRangeWithScores(ctx, key, -1, -1).Val(); len(val) >= 1 {
 		z := val[0]
 		e := time.Unix(int64(z.Score), 0)
 		if blocked = e.After(time.Now()); blocked {
@@ -67,5 +104,12 @@ func (b *sortedSetBlocker) State(id string) (exp time.Time, cause string, blocke
 }
 
 func (b *sortedSetBlocker) Clean(id string) error {
-	return b.client.Del(context.Background(), b.key(id)).Err()
+	return b.CleanContext(context.Background(), id)
+}
+
+func (b *sortedSetBlocker) CleanContext(ctx context.Context, id string) (err error) {
+	ctx, span := tracer().Start(ctx, "limiter.blocker.clean")
+	defer func() { endSpan(span, err) }()
+
+	return b.client.Del(ctx, b.key(id)).Err()
 }
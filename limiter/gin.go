@@ -28,7 +28,7 @@ func Available(group string, f WeightFunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		limiter := c.MustGet(defaultKey).(*Limiter)
 		w, key := f(c), c.ClientIP()
-		remain, err := limiter.Available(key, group, w)
+		remain, err := limiter.AvailableContext(c.Request.Context(), key, group, w)
 		if err != nil {
 			log.Errorf("check rate limit failed: %s", err)
 		}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/go-redis/redis"
 	uuid "github.com/gofrs/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type opt struct {
@@ -16,7 +18,7 @@ type opt struct {
 }
 
 type Limiter struct {
-	pool *redis.Client
+	pool redis.UniversalClient
 	opts map[string]*opt
 	mux  sync.Mutex
 }
@@ -36,7 +38,8 @@ func NewLimiter(addr string, password string, db int) (*Limiter, error) {
 	return NewLimiterWithClient(redisPool)
 }
 
-func NewLimiterWithClient(c *redis.Client) (*Limiter, error) {
+// NewLimiterWithClient c can be a single node, sentinel (failover) or cluster client
+func NewLimiterWithClient(c redis.UniversalClient) (*Limiter, error) {
 	if err := c.Ping(context.Background()).Err(); err != nil {
 		return nil, err
 	}
@@ -53,11 +56,29 @@ func (limiter *Limiter) AddGroup(group string, max int, window time.Duration) {
 	limiter.mux.Unlock()
 }
 
+// limiterKey the hash tag keeps every key of group & key in one cluster slot
 func limiterKey(group, key string) string {
-	return fmt.Sprintf("limiter:%s:%s", group, key)
+	return fmt.Sprintf("limiter:{%s:%s}", group, key)
 }
 
 func (limiter *Limiter) Available(key, group string, weight int) (int, error) {
+	return limiter.AvailableContext(context.Background(), key, group, weight)
+}
+
+// AvailableContext same as Available, the decision is traced as a child span of ctx
+func (limiter *Limiter) AvailableContext(ctx context.Context, key, group string, weight int) (remain int, err error) {
+	ctx, span := tracer().Start(ctx, "limiter.available", trace.WithAttributes(
+		attribute.String("limiter.group", group),
+		attribute.Int("limiter.weight", weight),
+	))
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("limiter.remain", remain),
+			attribute.Bool("limiter.limited", remain < 0),
+		)
+		endSpan(span, err)
+	}()
+
 	var (
 		max    = 0
 		window = time.Second
@@ -76,8 +97,8 @@ func (limiter *Limiter) Available(key, group string, weight int) (int, error) {
 	now := time.Now()
 	key = limiterKey(group, key)
 	var zcount *redis.IntCmd
-	_, err := limiter.pool.Pipelined(context.Background(), func(pipe redis.Pipeliner) error {
-		pipe.ZRemRangeByScore(context.Background(), key, "-inf", fmt.Sprint(now.Add(-window).UnixNano()/1000000))
+	_, err = limiter.pool.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint(now.Add(-window).UnixNano()/1000000))
 		if weight > 0 {
 			members := make([]redis.Z, 0, weight)
 			score := float64(now.UnixNano() / 1000000)
@@ -85,10 +106,10 @@ func (limiter *Limiter) Available(key, group string, weight int) (int, error) {
 				mem, _ := uuid.NewV4()
 				members = append(members, redis.Z{Score: score, Member: mem.String()})
 			}
-			pipe.ZAdd(context.Background(), key, members...)
+			pipe.ZAdd(ctx, key, members...)
 		}
-		pipe.Expire(context.Background(), key, time.Second*time.Duration(int64(window.Seconds())+60))
-		zcount = pipe.ZCount(context.Background(), key, "-inf", "+inf")
+		pipe.Expire(ctx, key, time.Second*time.Duration(int64(window.Seconds())+60))
+		zcount = pipe.ZCount(ctx, key, "-inf", "+inf")
 		return nil
 	})
 	if err != nil {
@@ -0,0 +1,163 @@
+package pagination
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrInvalidCursor cursor is malformed or its signature doesn't match
+var ErrInvalidCursor = errors.New("pagination: invalid cursor")
+
+// Cursor keyset cursor, Values hold the sort keys of the boundary row
+type Cursor struct {
+	Values []json.RawMessage `json:"v"`
+	// Backward cursor points to the previous page
+	Backward bool `json:"b,omitempty"`
+}
+
+// NewCursor new cursor with keyset values
+func NewCursor(values ...interface{}) *Cursor {
+	c := &Cursor{Values: make([]json.RawMessage, 0, len(values))}
+	for _, v := range values {
+		data, err := json.Marshal(v)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		c.Values = append(c.Values, data)
+	}
+
+	return c
+}
+
+// Reverse copy of the cursor pointing to the previous page
+func (c *Cursor) Reverse() *Cursor {
+	return &Cursor{Values: c.Values, Backward: !c.Backward}
+}
+
+// Scan decode keyset values into dst in order
+func (c *Cursor) Scan(dst ...interface{}) error {
+	if len(dst) > len(c.Values) {
+		return ErrInvalidCursor
+	}
+
+	for idx, v := range dst {
+		if err := json.Unmarshal(c.Values[idx], v); err != nil {
+			return ErrInvalidCursor
+		}
+	}
+
+	return nil
+}
+
+// String encode with the default signer, nil cursor is empty
+func (c *Cursor) String() string {
+	if c == nil {
+		return ""
+	}
+
+	return std().Encode(c)
+}
+
+// Signer encode & decode cursors signed with HMAC-SHA256
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner new signer with secret
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+func (s *Signer) sum(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Encode encode cursor into an opaque url safe token
+func (s *Signer) Encode(c *Cursor) string {
+	if c == nil {
+		return ""
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(append(payload, s.sum(payload)...))
+}
+
+// Decode decode token, empty token returns nil cursor
+func (s *Signer) Decode(token string) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) <= sha256.Size {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(sum, s.sum(payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+var (
+	stdSigner   atomic.Pointer[Signer]
+	stdFallback sync.Once
+)
+
+// SetSecret set secret of the default signer, call it before serving requests.
+// Without it a random secret is used, logged once, and cursors don't survive
+// restarts nor work across replicas
+func SetSecret(secret []byte) {
+	stdSigner.Store(NewSigner(secret))
+}
+
+func std() *Signer {
+	if s := stdSigner.Load(); s != nil {
+		return s
+	}
+
+	stdFallback.Do(func() {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Panic(err)
+		}
+
+		// a concurrent SetSecret wins
+		if stdSigner.CompareAndSwap(nil, NewSigner(secret)) {
+			log.Printf("pagination: SetSecret wasn't called, cursors are signed with a random secret and break on restarts & other replicas")
+		}
+	})
+
+	return stdSigner.Load()
+}
+
+// Encode encode cursor with the default signer
+func Encode(c *Cursor) string {
+	return std().Encode(c)
+}
+
+// Decode decode token with the default signer
+func Decode(token string) (*Cursor, error) {
+	return std().Decode(token)
+}
@@ -0,0 +1,65 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorEncode(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+
+	now := time.Unix(1600000000, 0).UTC()
+	token := s.Encode(NewCursor(now, int64(42)))
+
+	c, err := s.Decode(token)
+	if assert.Nil(t, err) {
+		var (
+			createdAt time.Time
+			id        int64
+		)
+
+		assert.Nil(t, c.Scan(&createdAt, &id))
+		assert.True(t, now.Equal(createdAt))
+		assert.Equal(t, int64(42), id)
+		assert.False(t, c.Backward)
+		assert.True(t, c.Reverse().Backward)
+	}
+
+	c, err = s.Decode("")
+	assert.Nil(t, err)
+	assert.Nil(t, c)
+}
+
+func TestCursorTampered(t *testing.T) {
+	token := NewSigner([]byte("secret")).Encode(NewCursor(1))
+
+	_, err := NewSigner([]byte("other")).Decode(token)
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	tampered := []byte(token)
+	tampered[5] ^= 1
+	_, err = NewSigner([]byte("secret")).Decode(string(tampered))
+	assert.Equal(t, ErrInvalidCursor, err)
+
+	_, err = NewSigner([]byte("secret")).Decode("not a cursor")
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestSetSecret(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Encode(NewCursor(1))
+	}()
+	SetSecret([]byte("secret"))
+	<-done
+
+	c, err := Decode(NewSigner([]byte("secret")).Encode(NewCursor(1)))
+	if assert.Nil(t, err) {
+		var id int
+		assert.Nil(t, c.Scan(&id))
+		assert.Equal(t, 1, id)
+	}
+}
@@ -0,0 +1,53 @@
+package pagination
+
+import (
+	"github.com/fox-one/gin-contrib/gin_helper"
+	"github.com/gin-gonic/gin"
+)
+
+// Query decoded pagination query
+type Query struct {
+	Cursor *Cursor
+	Limit  int
+}
+
+// Forward query from the first page or a next-page cursor
+func (q *Query) Forward() bool {
+	return q.Cursor == nil || !q.Cursor.Backward
+}
+
+// BindPagination read cursor & limit from query, limit is clamped by gin_helper.Limit
+func BindPagination(c *gin.Context, max, _default int) (*Query, error) {
+	var params struct {
+		Cursor string `form:"cursor"`
+		Limit  int    `form:"limit"`
+	}
+
+	if err := gin_helper.BindQuery(c, &params); err != nil {
+		return nil, err
+	}
+
+	cursor, err := Decode(params.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Query{
+		Cursor: cursor,
+		Limit:  gin_helper.Limit(params.Limit, max, _default),
+	}, nil
+}
+
+// Page next & previous cursors of a page, passed to gin_helper.OkWithPagination
+type Page struct {
+	Next *Cursor
+	Prev *Cursor
+}
+
+func (p Page) NextCursor() string {
+	return p.Next.String()
+}
+
+func (p Page) PrevCursor() string {
+	return p.Prev.String()
+}
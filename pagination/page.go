@@ -0,0 +1,65 @@
+package pagination
+
+import (
+	"math"
+
+	"github.com/fox-one/gin-contrib/gin_helper"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// PageQuery page-number query, page starts from 1
+type PageQuery struct {
+	Page     int `form:"page" json:"page"`
+	PageSize int `form:"page_size" json:"page_size"`
+}
+
+// Normalize clamp page size by gin_helper.Limit, page defaults to 1 and is
+// capped so that Offset doesn't overflow, such a page is empty anyway
+func (q *PageQuery) Normalize(max, _default int) {
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+
+	q.PageSize = gin_helper.Limit(q.PageSize, max, _default)
+	if q.PageSize > 0 && q.Page-1 > math.MaxInt/q.PageSize {
+		q.Page = math.MaxInt/q.PageSize + 1
+	}
+}
+
+// Offset offset of the first row
+func (q PageQuery) Offset() int {
+	return (q.Page - 1) * q.PageSize
+}
+
+// BindPage read page & page_size from query
+func BindPage(c *gin.Context, max, _default int) (*PageQuery, error) {
+	var q PageQuery
+	if err := gin_helper.BindQuery(c, &q); err != nil {
+		return nil, err
+	}
+
+	q.Normalize(max, _default)
+	return &q, nil
+}
+
+// Scope gorm scope applying limit & offset
+func (q PageQuery) Scope() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Offset(q.Offset()).Limit(q.PageSize)
+	}
+}
+
+// Find count rows matching filters and load the page into out, both run on
+// db, eg s.DBRead(). out must be a pointer to slice
+func (q PageQuery) Find(db *gorm.DB, out interface{}, filters ...func(db *gorm.DB) *gorm.DB) (total int, err error) {
+	db = db.Model(out).Scopes(filters...).Session(&gorm.Session{})
+
+	var count int64
+	if err = db.Count(&count).Error; err != nil || int(count) <= q.Offset() {
+		return int(count), err
+	}
+
+	err = db.Scopes(q.Scope()).Find(out).Error
+	return int(count), err
+}
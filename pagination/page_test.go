@@ -0,0 +1,96 @@
+package pagination
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+type testItem struct {
+	ID   int64
+	Name string
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, db.AutoMigrate(&testItem{}))
+	for idx := 1; idx <= 5; idx++ {
+		assert.Nil(t, db.Create(&testItem{ID: int64(idx), Name: string(rune('a' + idx - 1))}).Error)
+	}
+
+	return db
+}
+
+func TestPageQueryFind(t *testing.T) {
+	db := newTestDB(t)
+
+	var items []*testItem
+	q := PageQuery{Page: 2, PageSize: 2}
+	total, err := q.Find(db, &items, func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	})
+	if assert.Nil(t, err) && assert.Len(t, items, 2) {
+		assert.Equal(t, 5, total)
+		assert.Equal(t, int64(3), items[0].ID)
+		assert.Equal(t, int64(4), items[1].ID)
+	}
+
+	// past the last page only counts
+	items = nil
+	total, err = PageQuery{Page: 4, PageSize: 2}.Find(db, &items)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, total)
+	assert.Empty(t, items)
+
+	items = nil
+	total, err = PageQuery{Page: 1, PageSize: 10}.Find(db, &items, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id > ?", 3)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, total)
+	assert.Len(t, items, 2)
+}
+
+func TestPageQueryScope(t *testing.T) {
+	db := newTestDB(t)
+
+	var ids []int64
+	assert.Nil(t, db.Model(&testItem{}).Order("id").Scopes(PageQuery{Page: 3, PageSize: 2}.Scope()).Pluck("id", &ids).Error)
+	assert.Equal(t, []int64{5}, ids)
+}
+
+func TestBindPage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, tc := range []struct {
+		query    string
+		page     int
+		pageSize int
+	}{
+		{"", 1, 10},
+		{"page=3&page_size=20", 3, 20},
+		{"page=-1&page_size=500", 1, 50},
+		{"page=9223372036854775807&page_size=20", math.MaxInt/20 + 1, 20},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/?"+tc.query, nil)
+
+		q, err := BindPage(c, 50, 10)
+		if assert.Nil(t, err, tc.query) {
+			assert.Equal(t, tc.page, q.Page, tc.query)
+			assert.Equal(t, tc.pageSize, q.PageSize, tc.query)
+			assert.GreaterOrEqual(t, q.Offset(), 0, tc.query)
+		}
+	}
+}
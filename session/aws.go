@@ -0,0 +1,158 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	awsv1 "github.com/aws/aws-sdk-go/aws"
+	credentialsv1 "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/spf13/viper"
+)
+
+// awsConfig section of aws
+type awsConfig struct {
+	Region string
+	// Key & Secret static credentials, the default chain is used without them
+	Key          string
+	Secret       string
+	SessionToken string
+	// Profile shared config profile
+	Profile string
+	// RoleARN role assumed with the base credentials
+	RoleARN         string
+	RoleSessionName string
+	ExternalID      string
+	RoleDuration    time.Duration
+	// Endpoint base endpoint of every service, eg a local stand-in
+	Endpoint string
+}
+
+// parseAWSConfig nil if the aws section is missing
+func parseAWSConfig(v *viper.Viper) (*awsConfig, error) {
+	if v = v.Sub("aws"); v == nil {
+		return nil, nil
+	}
+
+	r := &configReader{v: v, prefix: "aws"}
+	cfg := &awsConfig{
+		Region:          r.String("region"),
+		Key:             r.String("key"),
+		Secret:          r.String("secret"),
+		SessionToken:    r.String("session_token"),
+		Profile:         r.String("profile"),
+		RoleARN:         r.String("role_arn"),
+		RoleSessionName: r.String("role_session_name"),
+		ExternalID:      r.String("external_id"),
+		RoleDuration:    r.Duration("role_duration"),
+		Endpoint:        r.String("endpoint"),
+	}
+
+	if (cfg.Key == "") != (cfg.Secret == "") {
+		r.fail("secret", errors.New("key and secret are set together"))
+	}
+
+	if cfg.Endpoint != "" {
+		if u, err := url.Parse(cfg.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			r.fail("endpoint", errors.New("must be an http or https url"))
+		}
+	}
+
+	if cfg.RoleDuration != 0 && cfg.RoleDuration < 15*time.Minute {
+		r.fail("role_duration", errors.New("must be at least 15m"))
+	}
+
+	return cfg, r.err
+}
+
+// openAWS sdk v2 config of the aws section, the default credential chain
+// (env, shared profile, web identity, instance role) unless key & secret
+// are set, then the role_arn is assumed with them
+func openAWS(v *viper.Viper) (*aws.Config, error) {
+	cfg, err := parseAWSConfig(v)
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+
+	if cfg.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.Profile))
+	}
+
+	if cfg.Key != "" {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(cfg.Key, cfg.Secret, cfg.SessionToken),
+		))
+	}
+
+	if cfg.Endpoint != "" {
+		opts = append(opts, awsconfig.WithBaseEndpoint(cfg.Endpoint))
+	}
+
+	// credentials are retrieved on first use, nothing is requested here
+	ac, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.RoleARN != "" {
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(ac), cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if cfg.RoleSessionName != "" {
+				o.RoleSessionName = cfg.RoleSessionName
+			}
+
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+
+			if cfg.RoleDuration > 0 {
+				o.Duration = cfg.RoleDuration
+			}
+		})
+		ac.Credentials = aws.NewCredentialsCache(provider)
+	}
+
+	return &ac, nil
+}
+
+// awsSession sdk v1 session of the aws section, static credentials only
+func awsSession(v *viper.Viper) *session.Session {
+	v = v.Sub("aws")
+	if v == nil {
+		return nil
+	}
+
+	var (
+		key    = v.GetString("key")
+		secret = v.GetString("secret")
+		region = v.GetString("region")
+	)
+
+	return session.New(&awsv1.Config{
+		Region:      awsv1.String(region),
+		Credentials: credentialsv1.NewStaticCredentials(key, secret, ""),
+	})
+}
+
+// AWSConfig aws sdk v2 config of the aws section, zero if it is missing,
+// eg s3.NewFromConfig(s.AWSConfig())
+func (s *Session) AWSConfig() aws.Config {
+	if b := s.backends; b != nil {
+		if cfg := b.awsConfig(); cfg != nil {
+			return cfg.Copy()
+		}
+	}
+
+	return aws.Config{}
+}
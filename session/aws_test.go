@@ -0,0 +1,53 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAWSConfig(t *testing.T) {
+	s, err := New([]byte(`
+aws:
+  region: us-east-1
+  key: AKID
+  secret: SECRET
+  endpoint: http://localhost:4566
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	cfg := s.AWSConfig()
+	assert.Equal(t, "us-east-1", cfg.Region)
+	assert.Equal(t, "http://localhost:4566", aws.ToString(cfg.BaseEndpoint))
+	creds, err := cfg.Credentials.Retrieve(context.Background())
+	if assert.Nil(t, err) {
+		assert.Equal(t, "AKID", creds.AccessKeyID)
+	}
+
+	s, err = New([]byte(`
+aws:
+  region: us-east-1
+  key: AKID
+  secret: SECRET
+  role_arn: arn:aws:iam::123456789012:role/app
+`))
+	if assert.Nil(t, err) {
+		defer s.Close()
+		_, ok := s.AWSConfig().Credentials.(*aws.CredentialsCache)
+		assert.True(t, ok)
+	}
+
+	_, err = New([]byte("aws:\n  endpoint: localhost:4566\n"))
+	assert.EqualError(t, err, "session: invalid config aws.endpoint: must be an http or https url")
+
+	s, err = New([]byte("app:\n  name: foo\n"))
+	if assert.Nil(t, err) {
+		defer s.Close()
+		assert.Nil(t, s.AWSConfig().Credentials)
+	}
+}
@@ -0,0 +1,616 @@
+package session
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	uuid "github.com/gofrs/uuid"
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrCacheMiss key isn't cached
+var ErrCacheMiss = errors.New("session: cache miss")
+
+// Codec serialization of cached values
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// JSONCodec default codec
+var JSONCodec Codec = jsonCodec{}
+
+type cacheOptions struct {
+	codec       Codec
+	ttl         time.Duration
+	localTTL    time.Duration
+	negativeTTL time.Duration
+	stale       time.Duration
+	beta        float64
+	lockTTL     time.Duration
+}
+
+// CacheOption option of NewCache
+type CacheOption func(*cacheOptions)
+
+// WithCodec serialize values with codec, default is JSONCodec
+func WithCodec(codec Codec) CacheOption {
+	return func(o *cacheOptions) {
+		o.codec = codec
+	}
+}
+
+// WithTTL expiration in redis, default is 10 minutes
+func WithTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.ttl = ttl
+	}
+}
+
+// WithLocalTTL expiration in memory, default is 1 minute. Local copies are
+// dropped by invalidations of other replicas, this bounds how long one can
+// be stale if a message is lost
+func WithLocalTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.localTTL = ttl
+	}
+}
+
+// WithNegativeTTL cache ErrNotFound of GetOrLoad loaders for ttl, off by default
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithStaleWhileRevalidate keep values for stale after they expire, GetOrLoad
+// serves a stale value and reloads it in the background
+func WithStaleWhileRevalidate(stale time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.stale = stale
+	}
+}
+
+// WithEarlyExpiration reload values in the background before they expire,
+// the closer to the expiration and the slower the load the more likely.
+// beta 1 is the usual value, greater reloads earlier, 0 disables it.
+// See "Optimal Probabilistic Cache Stampede Prevention"
+func WithEarlyExpiration(beta float64) CacheOption {
+	return func(o *cacheOptions) {
+		o.beta = beta
+	}
+}
+
+// WithLoadLock ttl of the redis lock letting one replica load a missing key
+// while the others wait for its value, default is 3 seconds, 0 disables it
+func WithLoadLock(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.lockTTL = ttl
+	}
+}
+
+// cacheEntry cached value, data is nil for a cached not found
+type cacheEntry struct {
+	data []byte
+	// expire the value is stale after
+	expire time.Time
+	// delta time the load took
+	delta time.Duration
+}
+
+// Size implement Sizer
+func (e *cacheEntry) Size() int {
+	return len(e.data)
+}
+
+func (e *cacheEntry) negative() bool {
+	return e.data == nil
+}
+
+// redis values are a flag byte, the expiration in unix ms, the load time in
+// ms and the data
+const (
+	cacheValue    = 'v'
+	cacheNotFound = 'n'
+
+	cacheHeaderSize = 1 + 8 + 4
+)
+
+func (e *cacheEntry) marshal() []byte {
+	b := make([]byte, cacheHeaderSize, cacheHeaderSize+len(e.data))
+	b[0] = cacheValue
+	if e.negative() {
+		b[0] = cacheNotFound
+	}
+
+	binary.BigEndian.PutUint64(b[1:], uint64(e.expire.UnixMilli()))
+	binary.BigEndian.PutUint32(b[9:], uint32(e.delta.Milliseconds()))
+	return append(b, e.data...)
+}
+
+func unmarshalCacheEntry(b []byte) (*cacheEntry, bool) {
+	if len(b) < cacheHeaderSize || (b[0] != cacheValue && b[0] != cacheNotFound) {
+		return nil, false
+	}
+
+	e := &cacheEntry{
+		expire: time.UnixMilli(int64(binary.BigEndian.Uint64(b[1:]))),
+		delta:  time.Duration(binary.BigEndian.Uint32(b[9:])) * time.Millisecond,
+	}
+
+	if b[0] == cacheValue {
+		e.data = b[cacheHeaderSize:]
+	}
+
+	return e, true
+}
+
+// cacheInvalidation message broadcast to the other replicas
+type cacheInvalidation struct {
+	ID   string   `json:"id"`
+	Keys []string `json:"keys"`
+}
+
+// Cache two tier cache, memory first and redis second. Writes & deletes are
+// broadcast so that other replicas drop their memory copies
+type Cache struct {
+	name     string
+	id       string
+	opts     cacheOptions
+	local    MemoryStore
+	backends *backends
+	group    singleflight.Group
+	// refreshing keys refreshed in the background, at most one refresh per key
+	refreshing sync.Map
+
+	// invalidations are received on the redis of sub, again on the new one
+	// once a reload replaces it
+	mu          sync.Mutex
+	sub         *redis.PubSub
+	subClient   redis.UniversalClient
+	unsubscribe func()
+	closed      bool
+}
+
+// NewCache cache on Memory & the default redis of s, keys are
+// prefixed by name. Without redis it is memory only
+func NewCache(s *Session, name string, opts ...CacheOption) *Cache {
+	c := &Cache{
+		name:     name,
+		id:       uuid.Must(uuid.NewV4()).String(),
+		local:    s.store,
+		backends: s.backends,
+		opts: cacheOptions{
+			codec:    JSONCodec,
+			ttl:      10 * time.Minute,
+			localTTL: time.Minute,
+			lockTTL:  3 * time.Second,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(&c.opts)
+	}
+
+	c.subscribe()
+	if s.backends != nil {
+		c.unsubscribe = s.Subscribe("redis", func(Change) {
+			c.subscribe()
+		})
+	}
+
+	return c
+}
+
+// Close stop listening to invalidations
+func (c *Cache) Close() error {
+	if c.unsubscribe != nil {
+		c.unsubscribe()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.sub != nil {
+		return c.sub.Close()
+	}
+
+	return nil
+}
+
+// redis the current default redis, nil if there is none
+func (c *Cache) redis() redis.UniversalClient {
+	if c.backends == nil {
+		return nil
+	}
+
+	return c.backends.redis("")
+}
+
+// subscribe listen to invalidations on the current redis, unless it is
+// already the one listened to
+func (c *Cache) subscribe() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	client := c.redis()
+	if c.closed || client == c.subClient {
+		return
+	}
+
+	if c.sub != nil {
+		c.sub.Close()
+	}
+
+	c.sub, c.subClient = nil, client
+	if client != nil {
+		c.sub = client.Subscribe(context.Background(), c.channel())
+		go c.listen(c.sub.Channel())
+	}
+}
+
+func (c *Cache) channel() string {
+	return "cache:" + c.name + ":invalidate"
+}
+
+func (c *Cache) key(key string) string {
+	return "cache:" + c.name + ":" + key
+}
+
+func (c *Cache) lockKey(key string) string {
+	return "cache:" + c.name + ":lock:" + key
+}
+
+func (c *Cache) listen(ch <-chan *redis.Message) {
+	for msg := range ch {
+		var inv cacheInvalidation
+		if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+			log.Printf("session: cache %s: bad invalidation: %v", c.name, err)
+			continue
+		}
+
+		if inv.ID == c.id {
+			continue
+		}
+
+		for _, key := range inv.Keys {
+			c.local.Delete(c.key(key))
+		}
+	}
+}
+
+func (c *Cache) publish(ctx context.Context, client redis.UniversalClient, keys []string) error {
+	payload, _ := json.Marshal(cacheInvalidation{ID: c.id, Keys: keys})
+	return client.Publish(ctx, c.channel(), payload).Err()
+}
+
+// setLocal keep e in memory for the local ttl, at most ttl
+func (c *Cache) setLocal(k string, e *cacheEntry, ttl time.Duration) {
+	if c.opts.localTTL < ttl {
+		ttl = c.opts.localTTL
+	}
+
+	if ttl > 0 {
+		c.local.Set(k, e, ttl)
+	}
+}
+
+// get the entry of key, memory first, nil on miss. Stale entries are returned
+func (c *Cache) get(ctx context.Context, key string) (*cacheEntry, error) {
+	k := c.key(key)
+	if v, ok := c.local.Get(k); ok {
+		return v.(*cacheEntry), nil
+	}
+
+	return c.getRedis(ctx, key)
+}
+
+// getRedis the entry of key in redis, kept in memory
+func (c *Cache) getRedis(ctx context.Context, key string) (*cacheEntry, error) {
+	client := c.redis()
+	if client == nil {
+		return nil, nil
+	}
+
+	k := c.key(key)
+	b, err := client.Get(ctx, k).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	e, ok := unmarshalCacheEntry(b)
+	if !ok {
+		return nil, nil
+	}
+
+	if ttl, err := client.PTTL(ctx, k).Result(); err == nil && ttl > 0 {
+		c.setLocal(k, e, ttl)
+	}
+
+	return e, nil
+}
+
+// set cache e, kept in redis for ttl plus the stale window of values
+func (c *Cache) set(ctx context.Context, key string, e *cacheEntry, ttl time.Duration) error {
+	e.expire = time.Now().Add(ttl)
+	if !e.negative() {
+		ttl += c.opts.stale
+	}
+
+	k := c.key(key)
+	c.setLocal(k, e, ttl)
+	client := c.redis()
+	if client == nil {
+		return nil
+	}
+
+	if err := client.Set(ctx, k, e.marshal(), ttl).Err(); err != nil {
+		return err
+	}
+
+	return c.publish(ctx, client, []string{key})
+}
+
+func (c *Cache) decode(e *cacheEntry, dst interface{}) error {
+	if e.negative() {
+		return ErrNotFound
+	}
+
+	return c.opts.codec.Unmarshal(e.data, dst)
+}
+
+// Get decode the cached value of key into dst, ErrCacheMiss if it isn't
+// cached, ErrNotFound if a not found is cached
+func (c *Cache) Get(ctx context.Context, key string, dst interface{}) error {
+	e, err := c.get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	if e == nil {
+		return ErrCacheMiss
+	}
+
+	return c.decode(e, dst)
+}
+
+func (c *Cache) encode(v interface{}) (*cacheEntry, error) {
+	data, err := c.opts.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		// nil data is a cached not found
+		data = []byte{}
+	}
+
+	return &cacheEntry{data: data}, nil
+}
+
+// Set cache v for key
+func (c *Cache) Set(ctx context.Context, key string, v interface{}) error {
+	e, err := c.encode(v)
+	if err != nil {
+		return err
+	}
+
+	return c.set(ctx, key, e, c.opts.ttl)
+}
+
+// Delete drop keys from memory & redis, on every replica
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	redisKeys := make([]string, len(keys))
+	for idx, key := range keys {
+		redisKeys[idx] = c.key(key)
+		c.local.Delete(redisKeys[idx])
+	}
+
+	client := c.redis()
+	if client == nil {
+		return nil
+	}
+
+	// keys may be on different cluster slots
+	for _, k := range redisKeys {
+		if err := client.Del(ctx, k).Err(); err != nil {
+			return err
+		}
+	}
+
+	return c.publish(ctx, client, keys)
+}
+
+// GetOrLoad decode the cached value of key into dst, on a miss the value
+// returned by load is cached first. An ErrNotFound of load is cached if
+// WithNegativeTTL is set. Concurrent loads of a key are coalesced in process,
+// and across replicas by a redis lock, see WithLoadLock. ctx of the first
+// caller is passed to load
+func (c *Cache) GetOrLoad(ctx context.Context, key string, dst interface{}, load func(ctx context.Context) (interface{}, error)) error {
+	e, err := c.get(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	switch now := time.Now(); {
+	case e == nil:
+		v, err, _ := c.group.Do(key, func() (interface{}, error) {
+			return c.loadLocked(ctx, key, load)
+		})
+		if err != nil {
+			return err
+		}
+
+		if e, _ = v.(*cacheEntry); e == nil {
+			if e, err = c.loadLocked(ctx, key, load); err != nil {
+				return err
+			}
+		}
+	case now.After(e.expire):
+		// stale while revalidate
+		c.refresh(ctx, key, load)
+	case c.expireEarly(e, now):
+		c.refresh(ctx, key, load)
+	}
+
+	return c.decode(e, dst)
+}
+
+// expireEarly XFetch, true with a probability growing as the expiration nears
+func (c *Cache) expireEarly(e *cacheEntry, now time.Time) bool {
+	if c.opts.beta <= 0 || e.delta <= 0 {
+		return false
+	}
+
+	r := rand.Float64()
+	if r == 0 {
+		return true
+	}
+
+	gap := -float64(e.delta) * c.opts.beta * math.Log(r)
+	return now.Add(time.Duration(gap)).After(e.expire)
+}
+
+// refresh reload key in the background, skipped if a refresh of key is in
+// flight or another replica is loading it. Refreshes are kept apart from the
+// loads of misses, a skipped refresh has no value to share with them
+func (c *Cache) refresh(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) {
+	if _, running := c.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer c.refreshing.Delete(key)
+
+		l, ok := c.lock(ctx, key)
+		if !ok {
+			return
+		}
+		defer c.unlock(ctx, key, l)
+
+		if _, err := c.load(ctx, key, load); err != nil {
+			log.Printf("session: cache %s: refresh %s failed: %v", c.name, key, err)
+		}
+	}()
+}
+
+// cacheLock load lock of a key, client is nil if no lock was taken
+type cacheLock struct {
+	client redis.UniversalClient
+	token  string
+}
+
+// lock take the load lock of key, true without redis or if disabled
+func (c *Cache) lock(ctx context.Context, key string) (cacheLock, bool) {
+	client := c.redis()
+	if client == nil || c.opts.lockTTL <= 0 {
+		return cacheLock{}, true
+	}
+
+	token, ok, err := tryLock(ctx, client, c.lockKey(key), c.opts.lockTTL)
+	if err != nil {
+		// load anyway if redis is unavailable
+		return cacheLock{}, true
+	}
+
+	if !ok {
+		return cacheLock{}, false
+	}
+
+	return cacheLock{client: client, token: token}, true
+}
+
+func (c *Cache) unlock(ctx context.Context, key string, l cacheLock) {
+	if l.client != nil {
+		releaseLock(ctx, l.client, c.lockKey(key), l.token)
+	}
+}
+
+// lockPoll interval of checking the value loaded by another replica
+const lockPoll = 50 * time.Millisecond
+
+// loadLocked load key holding the lock, or wait for the value loaded by the
+// holder. Loads anyway if the holder is gone or slower than the lock ttl
+func (c *Cache) loadLocked(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) (*cacheEntry, error) {
+	l, ok := c.lock(ctx, key)
+	if ok {
+		defer c.unlock(ctx, key, l)
+		return c.load(ctx, key, load)
+	}
+
+	deadline := time.Now().Add(c.opts.lockTTL)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPoll):
+		}
+
+		if e, err := c.getRedis(ctx, key); err == nil && e != nil {
+			return e, nil
+		}
+
+		client := c.redis()
+		if client == nil {
+			break
+		}
+
+		if n, err := client.Exists(ctx, c.lockKey(key)).Result(); err == nil && n == 0 {
+			break
+		}
+	}
+
+	return c.load(ctx, key, load)
+}
+
+func (c *Cache) load(ctx context.Context, key string, load func(ctx context.Context) (interface{}, error)) (*cacheEntry, error) {
+	start := time.Now()
+	v, err := load(ctx)
+	delta := time.Since(start)
+
+	e, ttl := &cacheEntry{}, c.opts.ttl
+	switch {
+	case IsErrNotFound(err) && c.opts.negativeTTL > 0:
+		ttl = c.opts.negativeTTL
+	case err != nil:
+		return nil, err
+	default:
+		if e, err = c.encode(v); err != nil {
+			return nil, err
+		}
+	}
+
+	e.delta = delta
+	// the loaded value is returned even if caching it fails
+	if err := c.set(ctx, key, e, ttl); err != nil {
+		log.Printf("session: cache %s: set %s failed: %v", c.name, key, err)
+	}
+
+	return e, nil
+}
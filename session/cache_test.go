@@ -0,0 +1,217 @@
+package session
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRedisSession(t *testing.T, mr *miniredis.Miniredis) *Session {
+	s, err := New([]byte("redis:\n  addr: " + mr.Addr() + "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestCache(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	a := NewCache(newRedisSession(t, mr), "users", WithNegativeTTL(time.Minute))
+	defer a.Close()
+	b := NewCache(newRedisSession(t, mr), "users")
+	defer b.Close()
+
+	var user testUser
+	assert.Equal(t, ErrCacheMiss, a.Get(ctx, "1", &user))
+
+	loads := 0
+	load := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return &testUser{ID: 1, Name: "foo"}, nil
+	}
+
+	assert.Nil(t, a.GetOrLoad(ctx, "1", &user, load))
+	assert.Nil(t, a.GetOrLoad(ctx, "1", &user, load))
+	assert.Equal(t, "foo", user.Name)
+	assert.Equal(t, 1, loads)
+
+	// b reads redis, then keeps a local copy
+	assert.Nil(t, b.Get(ctx, "1", &user))
+	assert.Equal(t, "foo", user.Name)
+
+	// the set on a drops the local copy of b
+	assert.Nil(t, a.Set(ctx, "1", &testUser{ID: 1, Name: "bar"}))
+	assert.Eventually(t, func() bool {
+		return b.Get(ctx, "1", &user) == nil && user.Name == "bar"
+	}, time.Second, 10*time.Millisecond)
+
+	assert.Nil(t, b.Delete(ctx, "1"))
+	assert.Eventually(t, func() bool {
+		return a.Get(ctx, "1", &user) == ErrCacheMiss
+	}, time.Second, 10*time.Millisecond)
+
+	// not found is cached
+	loads = 0
+	notFound := func(ctx context.Context) (interface{}, error) {
+		loads++
+		return nil, ErrNotFound
+	}
+	assert.Equal(t, ErrNotFound, a.GetOrLoad(ctx, "2", &user, notFound))
+	assert.Equal(t, ErrNotFound, a.GetOrLoad(ctx, "2", &user, notFound))
+	assert.Equal(t, ErrNotFound, b.Get(ctx, "2", &user))
+	assert.Equal(t, 1, loads)
+	assert.True(t, mr.TTL("cache:users:2") <= time.Minute)
+
+	// memory only without redis
+	c := NewCache(newSqliteSession(t), "users")
+	assert.Nil(t, c.Set(ctx, "1", &testUser{ID: 1, Name: "baz"}))
+	assert.Nil(t, c.Get(ctx, "1", &user))
+	assert.Equal(t, "baz", user.Name)
+}
+
+func TestCacheStampede(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	a := NewCache(newRedisSession(t, mr), "hot", WithTTL(100*time.Millisecond), WithStaleWhileRevalidate(time.Minute))
+	defer a.Close()
+	b := NewCache(newRedisSession(t, mr), "hot")
+	defer b.Close()
+
+	// concurrent loads are coalesced
+	var loads int32
+	load := func(ctx context.Context) (interface{}, error) {
+		n := atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return n, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var n int32
+			assert.Nil(t, a.GetOrLoad(ctx, "k", &n, load))
+			assert.EqualValues(t, 1, n)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&loads))
+
+	// the stale value is served while reloading
+	time.Sleep(150 * time.Millisecond)
+	var n int32
+	assert.Nil(t, a.GetOrLoad(ctx, "k", &n, load))
+	assert.EqualValues(t, 1, n)
+	assert.Eventually(t, func() bool {
+		return a.GetOrLoad(ctx, "k", &n, load) == nil && n == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// another replica holds the lock, its value is awaited
+	mr.Set("cache:hot:lock:other", "token")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		a.Set(ctx, "other", int32(42))
+	}()
+	assert.Nil(t, b.GetOrLoad(ctx, "other", &n, func(ctx context.Context) (interface{}, error) {
+		t.Error("loaded while locked")
+		return nil, nil
+	}))
+	assert.EqualValues(t, 42, n)
+}
+
+// blockHook block the first command named name until release is closed
+type blockHook struct {
+	name    string
+	once    sync.Once
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (h *blockHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *blockHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == h.name {
+			h.once.Do(func() {
+				close(h.blocked)
+				<-h.release
+			})
+		}
+
+		return next(ctx, cmd)
+	}
+}
+
+func (h *blockHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestCacheRefreshSkipped(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	s := newRedisSession(t, mr)
+
+	c := NewCache(s, "refresh", WithTTL(50*time.Millisecond), WithStaleWhileRevalidate(time.Minute), WithLoadLock(200*time.Millisecond))
+	defer c.Close()
+
+	var n int
+	assert.Nil(t, c.GetOrLoad(ctx, "k", &n, func(ctx context.Context) (interface{}, error) {
+		return 1, nil
+	}))
+	time.Sleep(60 * time.Millisecond)
+
+	// another replica holds the lock, the refresh is held before trying it
+	mr.Set("cache:refresh:lock:k", "other")
+	hook := &blockHook{name: "set", blocked: make(chan struct{}), release: make(chan struct{})}
+	s.Redis().AddHook(hook)
+
+	assert.Nil(t, c.GetOrLoad(ctx, "k", &n, func(ctx context.Context) (interface{}, error) {
+		return 2, nil
+	}))
+	assert.Equal(t, 1, n)
+	<-hook.blocked
+
+	// stale hits meanwhile don't start more refreshes
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, c.GetOrLoad(ctx, "k", &n, func(ctx context.Context) (interface{}, error) {
+			return 2, nil
+		}))
+	}
+	assert.Less(t, runtime.NumGoroutine()-goroutines, 10)
+
+	// a miss meanwhile doesn't share the skipped refresh
+	assert.Nil(t, c.Delete(ctx, "k"))
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(hook.release)
+	}()
+	assert.Nil(t, c.GetOrLoad(ctx, "k", &n, func(ctx context.Context) (interface{}, error) {
+		return 3, nil
+	}))
+	assert.Equal(t, 3, n)
+}
+
+func TestCacheEarlyExpiration(t *testing.T) {
+	c := NewCache(newSqliteSession(t), "early", WithEarlyExpiration(1))
+	now := time.Now()
+
+	assert.False(t, c.expireEarly(&cacheEntry{expire: now.Add(time.Hour), delta: time.Millisecond}, now))
+	assert.True(t, c.expireEarly(&cacheEntry{expire: now, delta: time.Second}, now))
+	assert.False(t, c.expireEarly(&cacheEntry{expire: now, delta: 0}, now))
+}
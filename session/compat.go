@@ -0,0 +1,81 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Helpers easing the move of SetdbFunc & query code from jinzhu/gorm v1 to
+// gorm.io/gorm v2. A v1 SetdbFunc like
+//
+//	func(db *gorm.DB) error {
+//		return db.AutoMigrate(&User{}).Model(&User{}).AddIndex("idx_name", "name").Error
+//	}
+//
+// becomes
+//
+//	SetdbFuncs(AutoMigrate(&User{}), AddIndex(&User{}, "idx_name", "name"))
+
+// SetdbFuncs chain fns into one, stops at the first error
+func SetdbFuncs(fns ...SetdbFunc) SetdbFunc {
+	return func(db *gorm.DB) error {
+		for _, fn := range fns {
+			if err := fn(db); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// AutoMigrate v1 db.AutoMigrate(models...).Error
+func AutoMigrate(models ...interface{}) SetdbFunc {
+	return func(db *gorm.DB) error {
+		return db.AutoMigrate(models...)
+	}
+}
+
+func addIndex(unique bool, model interface{}, name string, columns ...string) SetdbFunc {
+	return func(db *gorm.DB) error {
+		m := db.Migrator()
+		if m.HasIndex(model, name) {
+			return nil
+		}
+
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return err
+		}
+
+		sql := "CREATE INDEX %s ON %s (%s)"
+		if unique {
+			sql = "CREATE UNIQUE INDEX %s ON %s (%s)"
+		}
+
+		quoted := make([]string, len(columns))
+		for idx, c := range columns {
+			quoted[idx] = stmt.Quote(c)
+		}
+
+		return db.Exec(fmt.Sprintf(sql, stmt.Quote(name), stmt.Quote(stmt.Table), strings.Join(quoted, ","))).Error
+	}
+}
+
+// AddIndex v1 db.Model(model).AddIndex(name, columns...), skipped if the index exists
+func AddIndex(model interface{}, name string, columns ...string) SetdbFunc {
+	return addIndex(false, model, name, columns...)
+}
+
+// AddUniqueIndex v1 db.Model(model).AddUniqueIndex(name, columns...), skipped if the index exists
+func AddUniqueIndex(model interface{}, name string, columns ...string) SetdbFunc {
+	return addIndex(true, model, name, columns...)
+}
+
+// RecordNotFound v1 db.RecordNotFound()
+func RecordNotFound(db *gorm.DB) bool {
+	return errors.Is(db.Error, gorm.ErrRecordNotFound)
+}
@@ -0,0 +1,481 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// ConfigError invalid value of a config key
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (err *ConfigError) Error() string {
+	return fmt.Sprintf("session: invalid config %s: %s", err.Key, err.Err)
+}
+
+func (err *ConfigError) Unwrap() error {
+	return err.Err
+}
+
+// configReader read typed values, the first bad value is kept in err
+type configReader struct {
+	v      *viper.Viper
+	prefix string
+	err    error
+}
+
+func (r *configReader) fail(key string, err error) {
+	if r.err == nil {
+		r.err = &ConfigError{Key: r.prefix + "." + key, Err: err}
+	}
+}
+
+func (r *configReader) String(key string) string {
+	return r.v.GetString(key)
+}
+
+func (r *configReader) Int(key string, _default int) int {
+	if !r.v.IsSet(key) {
+		return _default
+	}
+
+	i, err := cast.ToIntE(r.v.Get(key))
+	if err != nil {
+		r.fail(key, err)
+	} else if i < 0 {
+		r.fail(key, fmt.Errorf("%d is negative", i))
+	}
+
+	return i
+}
+
+func (r *configReader) Bool(key string) bool {
+	if !r.v.IsSet(key) {
+		return false
+	}
+
+	b, err := cast.ToBoolE(r.v.Get(key))
+	if err != nil {
+		r.fail(key, err)
+	}
+
+	return b
+}
+
+// Duration accept duration strings like 30s, plain numbers are seconds
+func (r *configReader) Duration(key string) time.Duration {
+	if !r.v.IsSet(key) {
+		return 0
+	}
+
+	var (
+		d   time.Duration
+		err error
+	)
+
+	switch val := r.v.Get(key).(type) {
+	case string:
+		d, err = time.ParseDuration(val)
+	default:
+		var sec float64
+		sec, err = cast.ToFloat64E(val)
+		d = time.Duration(sec * float64(time.Second))
+	}
+
+	if err != nil {
+		r.fail(key, err)
+	} else if d < 0 {
+		r.fail(key, fmt.Errorf("%s is negative", d))
+	}
+
+	return d
+}
+
+func (r *configReader) StringMap(key string) map[string]string {
+	if !r.v.IsSet(key) {
+		return nil
+	}
+
+	m, err := cast.ToStringMapStringE(r.v.Get(key))
+	if err != nil {
+		r.fail(key, err)
+	}
+
+	return m
+}
+
+func (r *configReader) OneOf(key string, values ...string) string {
+	val := r.String(key)
+	for _, v := range values {
+		if val == v {
+			return val
+		}
+	}
+
+	r.fail(key, fmt.Errorf("%q is not one of %q", val, values))
+	return ""
+}
+
+const (
+	ReadPolicyRoundRobin = "round_robin"
+	ReadPolicyLeastConn  = "least_conn"
+	ReadPolicyRandom     = "random"
+)
+
+// dbConfig section of mysql, postgres & sqlite
+type dbConfig struct {
+	Name    string
+	dialect dialect
+
+	Username string
+	Password string
+	DB       string
+	// Reads read replicas, write is the first of them if empty
+	Reads []string
+	Write string
+
+	// ReadPolicy round_robin, least_conn or random
+	ReadPolicy           string
+	ReplicaCheckInterval time.Duration
+	// ReplicaMaxFailures failed checks in a row to take a replica out
+	ReplicaMaxFailures int
+	// MaxReplicationLag replicas lagging more are taken out, 0 disables
+	MaxReplicationLag time.Duration
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// TLS false, true, skip-verify or preferred
+	TLS          string
+	Timeout      time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	Loc          *time.Location
+	// Params extra dsn params
+	Params map[string]string
+}
+
+// DefaultInstance name of the instance configured by a flat section
+const DefaultInstance = "default"
+
+// instanceSections split a section into named instances. A section holding
+// any of flatKeys is a single instance named DefaultInstance, otherwise every
+// sub section is an instance, eg mysql.main & mysql.analytics
+func instanceSections(v *viper.Viper, flatKeys ...string) map[string]*viper.Viper {
+	for _, key := range flatKeys {
+		if v.IsSet(key) {
+			return map[string]*viper.Viper{DefaultInstance: v}
+		}
+	}
+
+	sections := make(map[string]*viper.Viper)
+	for key, val := range v.AllSettings() {
+		if _, ok := val.(map[string]interface{}); ok {
+			sections[key] = v.Sub(key)
+		}
+	}
+
+	return sections
+}
+
+// pickDefault the instance with default: true, or named default or main,
+// or the only one
+func pickDefault(section string, sections map[string]*viper.Viper) (string, error) {
+	var flagged []string
+	for name, v := range sections {
+		if v.GetBool("default") {
+			flagged = append(flagged, name)
+		}
+	}
+
+	switch {
+	case len(flagged) == 1:
+		return flagged[0], nil
+	case len(flagged) > 1:
+		sort.Strings(flagged)
+		return "", &ConfigError{Key: section, Err: fmt.Errorf("more than one default instance %q", flagged)}
+	}
+
+	for _, name := range []string{DefaultInstance, "main"} {
+		if _, ok := sections[name]; ok {
+			return name, nil
+		}
+	}
+
+	if len(sections) == 1 {
+		for name := range sections {
+			return name, nil
+		}
+	}
+
+	return "", &ConfigError{Key: section, Err: fmt.Errorf("no default instance, set default: true on one of them")}
+}
+
+var dbFlatKeys = []string{"username", "password", "db", "read", "write"}
+
+// loadDBConfigs load db instances of every mysql, postgres & sqlite section,
+// instance names are unique across sections
+func loadDBConfigs(v *viper.Viper) (cfgs map[string]*dbConfig, _default string, err error) {
+	cfgs = make(map[string]*dbConfig)
+	all := make(map[string]*viper.Viper)
+	for _, item := range dialects {
+		sub := v.Sub(item.name)
+		if sub == nil {
+			continue
+		}
+
+		for name, section := range instanceSections(sub, dbFlatKeys...) {
+			prefix := item.name
+			if name != DefaultInstance || section != sub {
+				prefix += "." + name
+			}
+
+			if _, ok := cfgs[name]; ok {
+				return nil, "", &ConfigError{Key: prefix, Err: fmt.Errorf("duplicated db instance %q", name)}
+			}
+
+			cfg, err := parseDBConfig(&configReader{v: section, prefix: prefix})
+			if err != nil {
+				return nil, "", err
+			}
+
+			cfg.Name, cfg.dialect = name, item.dialect
+			cfgs[name], all[name] = cfg, section
+		}
+	}
+
+	if len(cfgs) == 0 {
+		return nil, "", nil
+	}
+
+	_default, err = pickDefault("db", all)
+	return cfgs, _default, err
+}
+
+func parseDBConfig(r *configReader) (*dbConfig, error) {
+	cfg := &dbConfig{
+		Username:             r.String("username"),
+		Password:             r.String("password"),
+		DB:                   r.String("db"),
+		Reads:                r.v.GetStringSlice("read"),
+		Write:                r.String("write"),
+		ReadPolicy:           r.OneOf("read_policy", "", ReadPolicyRoundRobin, ReadPolicyLeastConn, ReadPolicyRandom),
+		ReplicaMaxFailures:   r.Int("replica_max_failures", 3),
+		ReplicaCheckInterval: r.Duration("replica_check_interval"),
+		MaxReplicationLag:    r.Duration("max_replication_lag"),
+		MaxOpenConns:         r.Int("max_open_conns", 0),
+		MaxIdleConns:         r.Int("max_idle_conns", 10),
+		ConnMaxLifetime:      r.Duration("conn_max_lifetime"),
+		ConnMaxIdleTime:      r.Duration("conn_max_idle_time"),
+		TLS:                  r.OneOf("tls", "", "false", "true", "skip-verify", "preferred"),
+		Timeout:              r.Duration("timeout"),
+		ReadTimeout:          r.Duration("read_timeout"),
+		WriteTimeout:         r.Duration("write_timeout"),
+		Params:               r.StringMap("params"),
+	}
+
+	if name := r.String("loc"); name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			r.fail("loc", err)
+		}
+
+		cfg.Loc = loc
+	}
+
+	if cfg.ReadPolicy == "" {
+		cfg.ReadPolicy = ReadPolicyRoundRobin
+	}
+
+	if cfg.ReplicaCheckInterval == 0 {
+		cfg.ReplicaCheckInterval = 10 * time.Second
+	}
+
+	if cfg.ReplicaMaxFailures == 0 {
+		r.fail("replica_max_failures", fmt.Errorf("must be positive"))
+	}
+
+	if cfg.MaxOpenConns > 0 && cfg.MaxIdleConns > cfg.MaxOpenConns {
+		r.fail("max_idle_conns", fmt.Errorf("%d is greater than max_open_conns %d", cfg.MaxIdleConns, cfg.MaxOpenConns))
+	}
+
+	return cfg, r.err
+}
+
+const (
+	RedisModeSingle   = "single"
+	RedisModeSentinel = "sentinel"
+	RedisModeCluster  = "cluster"
+)
+
+// redisConfig section of redis
+type redisConfig struct {
+	Name string
+	// Mode single, sentinel or cluster, default single
+	Mode string
+	Addr string
+	// Addrs sentinel addrs in sentinel mode, seed nodes in cluster mode
+	Addrs            []string
+	MasterName       string
+	SentinelPassword string
+	Password         string
+	DB               int
+	TLS              bool
+
+	PoolSize     int
+	MinIdleConns int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+}
+
+func parseRedisConfig(r *configReader) (*redisConfig, error) {
+	cfg := &redisConfig{
+		Mode:             r.OneOf("mode", "", RedisModeSingle, RedisModeSentinel, RedisModeCluster),
+		Addr:             r.String("addr"),
+		Addrs:            r.v.GetStringSlice("addrs"),
+		MasterName:       r.String("master_name"),
+		SentinelPassword: r.String("sentinel_password"),
+		Password:         r.String("password"),
+		DB:               r.Int("db", 0),
+		TLS:              r.Bool("tls"),
+		PoolSize:         r.Int("pool_size", 0),
+		MinIdleConns:     r.Int("min_idle_conns", 0),
+		DialTimeout:      r.Duration("dial_timeout"),
+		ReadTimeout:      r.Duration("read_timeout"),
+		WriteTimeout:     r.Duration("write_timeout"),
+		PoolTimeout:      r.Duration("pool_timeout"),
+	}
+
+	switch cfg.Mode {
+	case "", RedisModeSingle:
+		if cfg.Addr == "" {
+			r.fail("addr", fmt.Errorf("is empty"))
+		}
+	case RedisModeSentinel:
+		if cfg.MasterName == "" {
+			r.fail("master_name", fmt.Errorf("is empty"))
+		}
+
+		if len(cfg.Addrs) == 0 {
+			r.fail("addrs", fmt.Errorf("is empty"))
+		}
+	case RedisModeCluster:
+		if len(cfg.Addrs) == 0 && cfg.Addr != "" {
+			cfg.Addrs = []string{cfg.Addr}
+		}
+
+		if len(cfg.Addrs) == 0 {
+			r.fail("addrs", fmt.Errorf("is empty"))
+		}
+
+		if cfg.DB != 0 {
+			r.fail("db", fmt.Errorf("cluster only supports db 0"))
+		}
+	}
+
+	return cfg, r.err
+}
+
+var redisFlatKeys = []string{"mode", "addr", "addrs", "master_name", "db"}
+
+// loadRedisConfigs load redis instances, eg redis.cache & redis.limiter
+func loadRedisConfigs(v *viper.Viper) (cfgs map[string]*redisConfig, _default string, err error) {
+	v = v.Sub("redis")
+	if v == nil {
+		return nil, "", nil
+	}
+
+	cfgs = make(map[string]*redisConfig)
+	sections := instanceSections(v, redisFlatKeys...)
+	for name, section := range sections {
+		prefix := "redis"
+		if section != v {
+			prefix += "." + name
+		}
+
+		cfg, err := parseRedisConfig(&configReader{v: section, prefix: prefix})
+		if err != nil {
+			return nil, "", err
+		}
+
+		cfg.Name = name
+		cfgs[name] = cfg
+	}
+
+	if len(cfgs) == 0 {
+		return nil, "", nil
+	}
+
+	_default, err = pickDefault("redis", sections)
+	return cfgs, _default, err
+}
+
+// memoryConfig section of memory
+type memoryConfig struct {
+	DefaultTTL      time.Duration
+	CleanupInterval time.Duration
+	// MaxEntries & MaxBytes bound the store, 0 is unbounded
+	MaxEntries int
+	MaxBytes   int
+	// Eviction lru or lfu, default lru
+	Eviction string
+}
+
+func parseMemoryConfig(v *viper.Viper) (*memoryConfig, error) {
+	cfg := &memoryConfig{DefaultTTL: time.Hour, CleanupInterval: 10 * time.Minute, Eviction: EvictionLRU}
+	if v = v.Sub("memory"); v == nil {
+		return cfg, nil
+	}
+
+	r := &configReader{v: v, prefix: "memory"}
+	if v.IsSet("default_ttl") {
+		cfg.DefaultTTL = r.Duration("default_ttl")
+	}
+
+	if v.IsSet("cleanup_interval") {
+		cfg.CleanupInterval = r.Duration("cleanup_interval")
+	}
+
+	cfg.MaxEntries = r.Int("max_entries", 0)
+	cfg.MaxBytes = r.Int("max_bytes", 0)
+	if eviction := r.OneOf("eviction", "", EvictionLRU, EvictionLFU); eviction != "" {
+		cfg.Eviction = eviction
+	}
+
+	return cfg, r.err
+}
+
+// validateConfig check the backend sections without connecting
+func validateConfig(v *viper.Viper) error {
+	if _, _, err := loadDBConfigs(v); err != nil {
+		return err
+	}
+
+	if _, _, err := loadRedisConfigs(v); err != nil {
+		return err
+	}
+
+	if _, err := parseMemoryConfig(v); err != nil {
+		return err
+	}
+
+	if _, err := parseAWSConfig(v); err != nil {
+		return err
+	}
+
+	if _, err := parseStorageConfig(v); err != nil {
+		return err
+	}
+
+	return nil
+}
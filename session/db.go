@@ -1,7 +1,14 @@
 package session
 
 import (
-	"github.com/jinzhu/gorm"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/callbacks"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/migrator"
+	"gorm.io/gorm/schema"
 )
 
 type SetdbFunc func(db *gorm.DB) error
@@ -12,13 +19,68 @@ func RegisterSetdb(fn SetdbFunc) {
 	setdbFuncs = append(setdbFuncs, fn)
 }
 
+// Setdb run every registered SetdbFunc, then apply the pending migrations,
+// both holding the migration lock
 func Setdb(s *Session) error {
-	db := s.MysqlWrite().Debug()
-	for _, fn := range setdbFuncs {
-		if err := fn(db); err != nil {
-			db.AddError(err)
+	m := NewMigrator(s)
+	return m.withLock(func(db *gorm.DB) error {
+		debug := db.Debug()
+		for _, fn := range setdbFuncs {
+			if err := fn(debug); err != nil {
+				debug.AddError(err)
+			}
+		}
+
+		if debug.Error != nil {
+			return debug.Error
 		}
-	}
 
-	return db.Error
+		return m.up(debug)
+	})
+}
+
+// noDBDialector dialector of the db returned for an unconfigured instance, it
+// never connects, statements only carry ErrNoDB
+type noDBDialector struct{}
+
+func (noDBDialector) Name() string { return "none" }
+
+func (noDBDialector) Initialize(db *gorm.DB) error {
+	// the default callbacks skip statements with an error
+	callbacks.RegisterDefaultCallbacks(db, &callbacks.Config{})
+	return nil
+}
+
+func (d noDBDialector) Migrator(db *gorm.DB) gorm.Migrator {
+	return migrator.Migrator{Config: migrator.Config{DB: db, Dialector: d}}
+}
+
+func (noDBDialector) DataTypeOf(*schema.Field) string { return "" }
+
+func (noDBDialector) DefaultValueOf(*schema.Field) clause.Expression {
+	return clause.Expr{SQL: "DEFAULT"}
+}
+
+func (noDBDialector) BindVarTo(w clause.Writer, _ *gorm.Statement, _ interface{}) {
+	w.WriteByte('?')
+}
+
+func (noDBDialector) QuoteTo(w clause.Writer, str string) { w.WriteString(str) }
+
+func (noDBDialector) Explain(sql string, _ ...interface{}) string { return sql }
+
+var (
+	noDBOnce sync.Once
+	noDBBase *gorm.DB
+)
+
+// noDB db whose statements fail with ErrNoDB
+func noDB() *gorm.DB {
+	noDBOnce.Do(func() {
+		noDBBase, _ = gorm.Open(noDBDialector{}, &gorm.Config{Logger: logger.Discard})
+	})
+
+	db := noDBBase.Session(&gorm.Session{NewDB: true})
+	db.AddError(ErrNoDB)
+	return db
 }
@@ -0,0 +1,252 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// dialect database dialect, the config section has the same name
+type dialect struct {
+	// driver database/sql driver name
+	driver string
+	// dsn build dsn of host, host is a file path for sqlite
+	dsn func(host string, cfg *dbConfig) string
+	// gorm dialector on an opened pool or a pinned connection, lazy skips
+	// queries on initialization
+	gorm func(conn gorm.ConnPool, lazy bool) gorm.Dialector
+	// lag replication lag of a replica, nil if the dialect can't tell
+	lag func(ctx context.Context, db *sql.DB) (time.Duration, error)
+	// lock & unlock advisory lock held by conn, nil if the dialect has none
+	lock   func(ctx context.Context, conn *sql.Conn, name string) error
+	unlock func(ctx context.Context, conn *sql.Conn, name string) error
+	// transactionalDDL schema changes can be rolled back
+	transactionalDDL bool
+}
+
+func mysqlLock(ctx context.Context, conn *sql.Conn, name string) error {
+	// GET_LOCK waits at most the timeout in seconds, -1 is forever
+	var ok sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", name).Scan(&ok); err != nil {
+		return err
+	}
+
+	if ok.Int64 != 1 {
+		return fmt.Errorf("get lock %s failed", name)
+	}
+
+	return nil
+}
+
+func mysqlUnlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	return err
+}
+
+// postgresLockKey advisory locks take an int64 key
+func postgresLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+func postgresLock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresLockKey(name))
+	return err
+}
+
+func postgresUnlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", postgresLockKey(name))
+	return err
+}
+
+// errReplicationStopped replica reports no lag, replication isn't running
+var errReplicationStopped = errors.New("replication is not running")
+
+func mysqlLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	rows, err := db.QueryContext(ctx, "SHOW REPLICA STATUS")
+	if err != nil {
+		// before mysql 8.0.22
+		rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS")
+	}
+
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return 0, err
+		}
+
+		return 0, errReplicationStopped
+	}
+
+	values := make([]sql.NullString, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return 0, err
+	}
+
+	for i, col := range cols {
+		if col != "Seconds_Behind_Source" && col != "Seconds_Behind_Master" {
+			continue
+		}
+
+		if !values[i].Valid {
+			return 0, errReplicationStopped
+		}
+
+		sec, err := strconv.Atoi(values[i].String)
+		return time.Duration(sec) * time.Second, err
+	}
+
+	return 0, errReplicationStopped
+}
+
+func postgresLag(ctx context.Context, db *sql.DB) (time.Duration, error) {
+	// a replica of an idle primary lags by the time since the last commit
+	const query = "SELECT CASE WHEN pg_is_in_recovery() THEN " +
+		"COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), -1) ELSE 0 END"
+
+	var sec float64
+	if err := db.QueryRowContext(ctx, query).Scan(&sec); err != nil {
+		return 0, err
+	}
+
+	if sec < 0 {
+		return 0, errReplicationStopped
+	}
+
+	return time.Duration(sec * float64(time.Second)), nil
+}
+
+func mysqlDSN(host string, cfg *dbConfig) string {
+	c := driver.NewConfig()
+	c.User = cfg.Username
+	c.Passwd = cfg.Password
+	c.Net = "tcp"
+	c.Addr = host
+	c.DBName = cfg.DB
+	c.ParseTime = true
+	c.TLSConfig = cfg.TLS
+	c.Timeout = cfg.Timeout
+	c.ReadTimeout = cfg.ReadTimeout
+	c.WriteTimeout = cfg.WriteTimeout
+	if cfg.Loc != nil {
+		c.Loc = cfg.Loc
+	}
+
+	c.Params = map[string]string{"charset": "utf8mb4"}
+	for k, v := range cfg.Params {
+		c.Params[k] = v
+	}
+
+	return c.FormatDSN()
+}
+
+// postgres sslmode of the tls option
+var postgresSSLModes = map[string]string{
+	"":            "disable",
+	"false":       "disable",
+	"true":        "verify-full",
+	"skip-verify": "require",
+	"preferred":   "prefer",
+}
+
+func postgresDSN(host string, cfg *dbConfig) string {
+	query := url.Values{}
+	query.Set("sslmode", postgresSSLModes[cfg.TLS])
+	if cfg.Timeout > 0 {
+		query.Set("connect_timeout", strconv.Itoa(int(cfg.Timeout.Seconds())))
+	}
+
+	if cfg.Loc != nil {
+		query.Set("timezone", cfg.Loc.String())
+	}
+
+	for k, v := range cfg.Params {
+		query.Set(k, v)
+	}
+
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(cfg.Username, cfg.Password),
+		Host:     host,
+		Path:     "/" + cfg.DB,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+func sqliteDSN(host string, cfg *dbConfig) string {
+	if len(cfg.Params) == 0 {
+		return host
+	}
+
+	query := url.Values{}
+	for k, v := range cfg.Params {
+		query.Set(k, v)
+	}
+
+	return host + "?" + query.Encode()
+}
+
+// dialects in the order the config sections are looked up
+var dialects = []struct {
+	name string
+	dialect
+}{
+	{"mysql", dialect{
+		driver: "mysql",
+		dsn:    mysqlDSN,
+		gorm: func(conn gorm.ConnPool, lazy bool) gorm.Dialector {
+			return mysql.New(mysql.Config{Conn: conn, SkipInitializeWithVersion: lazy})
+		},
+		lag:    mysqlLag,
+		lock:   mysqlLock,
+		unlock: mysqlUnlock,
+	}},
+	{"postgres", dialect{
+		driver: "pgx",
+		dsn:    postgresDSN,
+		gorm: func(conn gorm.ConnPool, _ bool) gorm.Dialector {
+			return postgres.New(postgres.Config{Conn: conn})
+		},
+		lag:              postgresLag,
+		lock:             postgresLock,
+		unlock:           postgresUnlock,
+		transactionalDDL: true,
+	}},
+	{"sqlite", dialect{
+		driver: sqlite.DriverName,
+		dsn:    sqliteDSN,
+		gorm: func(conn gorm.ConnPool, _ bool) gorm.Dialector {
+			return &sqlite.Dialector{DriverName: sqlite.DriverName, Conn: conn}
+		},
+		// a single writer, no lock needed
+		transactionalDDL: true,
+	}},
+}
@@ -0,0 +1,287 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// WithEnv override config keys by environment variables, the key is upper
+// cased with . replaced by _ and prefixed by prefix_, eg APP_MYSQL_PASSWORD
+// for mysql.password with prefix APP. Keys present in the config are
+// overridden, and so are the known keys of the session sections present in
+// it, see sectionKeys. Lists are comma separated
+func WithEnv(prefix string) Option {
+	return func(o *options) {
+		o.env = true
+		o.envPrefix = prefix
+	}
+}
+
+// WithInterpolation replace ${NAME} in every string value of the config by
+// the environment variable NAME, an unset one is a ConfigError. $${ is a
+// literal ${
+func WithInterpolation() Option {
+	return func(o *options) {
+		o.interpolate = true
+	}
+}
+
+// secretSections sections whose key_file values are read into key
+var secretSections = []string{"mysql", "postgres", "sqlite", "redis", "aws", "storage", "memory"}
+
+// envRef ${NAME}, or the escape $${ of a literal ${
+var envRef = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// keys of the session sections that WithEnv sets even if the config lacks them
+var (
+	dbEnvKeys = []string{
+		"username", "password", "password_file", "db", "read", "write", "read_policy",
+		"replica_max_failures", "replica_check_interval", "max_replication_lag",
+		"max_open_conns", "max_idle_conns", "conn_max_lifetime", "conn_max_idle_time",
+		"tls", "timeout", "read_timeout", "write_timeout", "loc",
+	}
+	redisEnvKeys = []string{
+		"mode", "addr", "addrs", "master_name", "sentinel_password", "sentinel_password_file",
+		"password", "password_file", "db", "tls", "pool_size", "min_idle_conns",
+		"dial_timeout", "read_timeout", "write_timeout", "pool_timeout",
+	}
+
+	sectionKeys = map[string][]string{
+		"mysql":    dbEnvKeys,
+		"postgres": dbEnvKeys,
+		"sqlite":   dbEnvKeys,
+		"redis":    redisEnvKeys,
+		"aws": {
+			"region", "key", "key_file", "secret", "secret_file", "session_token", "profile",
+			"role_arn", "role_session_name", "external_id", "role_duration", "endpoint",
+		},
+		"storage": {
+			"driver", "prefix", "bucket", "path_style", "part_size", "concurrency",
+			"dir", "base_url", "secret", "secret_file",
+		},
+		"memory": {"default_ttl", "cleanup_interval", "max_entries", "max_bytes", "eviction"},
+	}
+
+	// instanceFlatKeys keys telling a section of a single instance from a
+	// section of named instances, see instanceSections
+	instanceFlatKeys = map[string][]string{
+		"mysql":    dbFlatKeys,
+		"postgres": dbFlatKeys,
+		"sqlite":   dbFlatKeys,
+		"redis":    redisFlatKeys,
+	}
+
+	// listKeys keys holding a list
+	listKeys = map[string]bool{"read": true, "addrs": true}
+)
+
+// envKey environment variable overriding key
+func envKey(prefix, key string) string {
+	name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+	if prefix != "" {
+		name = strings.ToUpper(prefix) + "_" + name
+	}
+
+	return name
+}
+
+// walkSettings call fn on every leaf of m, fn returns the new value
+func walkSettings(m map[string]interface{}, path string, fn func(key string, val interface{}) (interface{}, error)) error {
+	for k, val := range m {
+		key := k
+		if path != "" {
+			key = path + "." + k
+		}
+
+		if sub, ok := val.(map[string]interface{}); ok {
+			if err := walkSettings(sub, key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		v, err := fn(key, val)
+		if err != nil {
+			return err
+		}
+		m[k] = v
+	}
+
+	return nil
+}
+
+// interpolate replace ${NAME} by the environment variable NAME, unset ones are
+// an error. $${ is a literal ${
+func interpolate(key, s string) (string, error) {
+	var err error
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+
+		name := envRef.FindStringSubmatch(ref)[1]
+		val, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = &ConfigError{Key: key, Err: fmt.Errorf("environment variable %s is not set", name)}
+		}
+
+		return val
+	})
+
+	return out, err
+}
+
+// resolveSecretFiles set key to the content of the file at key_file
+func resolveSecretFiles(m map[string]interface{}, path string) error {
+	for k, val := range m {
+		key := k
+		if path != "" {
+			key = path + "." + k
+		}
+
+		switch val := val.(type) {
+		case map[string]interface{}:
+			if err := resolveSecretFiles(val, key); err != nil {
+				return err
+			}
+		case string:
+			if !strings.HasSuffix(k, "_file") || val == "" {
+				continue
+			}
+
+			data, err := os.ReadFile(val)
+			if err != nil {
+				return &ConfigError{Key: key, Err: err}
+			}
+
+			m[strings.TrimSuffix(k, "_file")] = strings.TrimRight(string(data), "\r\n")
+		}
+	}
+
+	return nil
+}
+
+// envInstances instances of a session section, the section itself if it is
+// a single instance
+func envInstances(section string, m map[string]interface{}) map[string]map[string]interface{} {
+	single := map[string]map[string]interface{}{section: m}
+	flatKeys, ok := instanceFlatKeys[section]
+	if !ok {
+		return single
+	}
+
+	for _, key := range flatKeys {
+		if _, ok := m[key]; ok {
+			return single
+		}
+	}
+
+	instances := make(map[string]map[string]interface{})
+	for name, val := range m {
+		if sub, ok := val.(map[string]interface{}); ok {
+			instances[section+"."+name] = sub
+		}
+	}
+
+	if len(instances) == 0 {
+		return single
+	}
+
+	return instances
+}
+
+// applySectionEnv set the known keys of the session sections in settings
+// from the environment, the keys present are already overridden
+func applySectionEnv(settings map[string]interface{}, prefix string) {
+	for section, keys := range sectionKeys {
+		m, ok := settings[section].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for path, inst := range envInstances(section, m) {
+			for _, key := range keys {
+				if _, ok := inst[key]; ok {
+					continue
+				}
+
+				env, ok := os.LookupEnv(envKey(prefix, path+"."+key))
+				if !ok {
+					continue
+				}
+
+				if listKeys[key] {
+					inst[key] = strings.Split(env, ",")
+				} else {
+					inst[key] = env
+				}
+			}
+		}
+	}
+}
+
+// applyEnv interpolate ${NAME} and override keys by environment variables if
+// enabled, then read key_file secrets of the session sections. The result
+// is a copy of v, so it survives viper.Sub and v is left as is
+func applyEnv(v *viper.Viper, o *options) (*viper.Viper, error) {
+	settings := v.AllSettings()
+	err := walkSettings(settings, "", func(key string, val interface{}) (interface{}, error) {
+		if o.env {
+			if env, ok := os.LookupEnv(envKey(o.envPrefix, key)); ok {
+				if _, ok := val.([]interface{}); ok {
+					return strings.Split(env, ","), nil
+				}
+
+				return env, nil
+			}
+		}
+
+		if !o.interpolate {
+			return val, nil
+		}
+
+		switch val := val.(type) {
+		case string:
+			return interpolate(key, val)
+		case []interface{}:
+			for idx, item := range val {
+				if s, ok := item.(string); ok {
+					out, err := interpolate(key, s)
+					if err != nil {
+						return nil, err
+					}
+					val[idx] = out
+				}
+			}
+		}
+
+		return val, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.env {
+		applySectionEnv(settings, o.envPrefix)
+	}
+
+	for _, section := range secretSections {
+		if sub, ok := settings[section].(map[string]interface{}); ok {
+			if err := resolveSecretFiles(sub, section); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	out := viper.New()
+	out.SetConfigFile(v.ConfigFileUsed())
+	if err := out.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
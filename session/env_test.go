@@ -0,0 +1,80 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnv(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	assert.Nil(t, os.WriteFile(secret, []byte("s3cret\n"), 0600))
+
+	t.Setenv("TEST_HOST", "10.0.0.1")
+	t.Setenv("APP_MYSQL_USERNAME", "env-user")
+	t.Setenv("APP_MYSQL_READ", "10.0.0.2:3306,10.0.0.3:3306")
+	t.Setenv("APP_MYSQL_PASSWORD_FILE", secret)
+	t.Setenv("APP_MYSQL_MAX_OPEN_CONNS", "20")
+	t.Setenv("APP_REDIS_CACHE_PASSWORD", "redis-secret")
+	t.Setenv("APP_APP_TOKEN", "not a known key")
+
+	s, err := New([]byte(`
+mysql:
+  username: root
+  write: ${TEST_HOST}:3306
+  read: [a]
+redis:
+  cache:
+    addr: localhost:6379
+app:
+  url: http://${TEST_HOST}/
+  template: $${NAME} is kept
+`), WithEnv("app"), WithInterpolation(), WithLazyConnect())
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	sub := s.SubViper("mysql").Viper()
+	assert.Equal(t, "env-user", sub.GetString("username"))
+	assert.Equal(t, "s3cret", sub.GetString("password"))
+	assert.Equal(t, "10.0.0.1:3306", sub.GetString("write"))
+	assert.Equal(t, []string{"10.0.0.2:3306", "10.0.0.3:3306"}, sub.GetStringSlice("read"))
+	assert.Equal(t, 20, sub.GetInt("max_open_conns"))
+	assert.Equal(t, "redis-secret", s.Viper().GetString("redis.cache.password"))
+	assert.Equal(t, "http://10.0.0.1/", s.Viper().GetString("app.url"))
+	assert.Equal(t, "${NAME} is kept", s.Viper().GetString("app.template"))
+	assert.False(t, s.Viper().IsSet("app.token"))
+
+	// interpolation is opt-in
+	s2, err := New([]byte("app:\n  url: ${TEST_UNSET_VARIABLE}\n"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "${TEST_UNSET_VARIABLE}", s2.Viper().GetString("app.url"))
+		s2.Close()
+	}
+
+	_, err = New([]byte("app:\n  url: ${TEST_UNSET_VARIABLE}\n"), WithInterpolation())
+	if assert.IsType(t, &ConfigError{}, err) {
+		assert.Equal(t, "app.url", err.(*ConfigError).Key)
+	}
+
+	// the caller's viper is left as is
+	v := viper.New()
+	v.SetConfigType("yaml")
+	assert.Nil(t, v.ReadConfig(strings.NewReader("mysql:\n  username: root\n  read: [a]\n")))
+	s3, err := Open(v, WithEnv("app"), WithLazyConnect())
+	if assert.Nil(t, err) {
+		assert.Equal(t, "env-user", s3.Viper().GetString("mysql.username"))
+		s3.Close()
+	}
+	assert.Equal(t, "root", v.GetString("mysql.username"))
+	assert.False(t, v.IsSet("mysql.password"))
+
+	_, err = New([]byte("redis:\n  addr: x\n  password_file: " + filepath.Join(dir, "missing") + "\n"))
+	assert.IsType(t, &ConfigError{}, err)
+}
@@ -0,0 +1,135 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/gorm"
+)
+
+// mysql error numbers
+const (
+	mysqlDuplicateEntry   = 1062
+	mysqlLockWaitTimeout  = 1205
+	mysqlDeadlock         = 1213
+	mysqlQueryInterrupted = 3024
+)
+
+// postgres sqlstates
+const (
+	pgUniqueViolation      = "23505"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	pgLockNotAvailable     = "55P03"
+	pgQueryCanceled        = "57014"
+)
+
+func mysqlErrorNumber(err error) uint16 {
+	var e *driver.MySQLError
+	if errors.As(err, &e) {
+		return e.Number
+	}
+
+	return 0
+}
+
+func pgErrorCode(err error) string {
+	var e *pgconn.PgError
+	if errors.As(err, &e) {
+		return e.Code
+	}
+
+	return ""
+}
+
+func sqliteError(err error) (sqlite3.Error, bool) {
+	var e sqlite3.Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+// IsDuplicateKey is err a unique key violation
+func IsDuplicateKey(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, gorm.ErrDuplicatedKey) || mysqlErrorNumber(err) == mysqlDuplicateEntry || pgErrorCode(err) == pgUniqueViolation {
+		return true
+	}
+
+	e, ok := sqliteError(err)
+	return ok && (e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey)
+}
+
+// IsDeadlock is err a deadlock or a serialization failure,
+// the transaction is rolled back and can be retried
+func IsDeadlock(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if mysqlErrorNumber(err) == mysqlDeadlock {
+		return true
+	}
+
+	switch pgErrorCode(err) {
+	case pgDeadlockDetected, pgSerializationFailure:
+		return true
+	}
+
+	return false
+}
+
+// IsTimeout is err a lock wait timeout, a canceled query or a timed out context
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if isLockTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	if mysqlErrorNumber(err) == mysqlQueryInterrupted || pgErrorCode(err) == pgQueryCanceled {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isLockTimeout lock wait timed out, only the statement failed so the
+// transaction is retryable
+func isLockTimeout(err error) bool {
+	if mysqlErrorNumber(err) == mysqlLockWaitTimeout || pgErrorCode(err) == pgLockNotAvailable {
+		return true
+	}
+
+	e, ok := sqliteError(err)
+	return ok && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+// isRetryable transaction failed by a deadlock or a lock wait timeout
+func isRetryable(err error) bool {
+	return IsDeadlock(err) || isLockTimeout(err)
+}
+
+// IsDuplicateKey is err a unique key violation
+func (s *Session) IsDuplicateKey(err error) bool {
+	return IsDuplicateKey(err)
+}
+
+// IsDeadlock is err a deadlock or a serialization failure
+func (s *Session) IsDeadlock(err error) bool {
+	return IsDeadlock(err)
+}
+
+// IsTimeout is err a lock wait timeout, a canceled query or a timed out context
+func (s *Session) IsTimeout(err error) bool {
+	return IsTimeout(err)
+}
@@ -0,0 +1,376 @@
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fsMetaDir directory of the object metadata, under the storage dir
+const fsMetaDir = ".meta"
+
+// FSStorage storage on a local directory, for tests & local development.
+// Presigned urls are served by the storage itself as an http.Handler
+type FSStorage struct {
+	dir     string
+	baseURL *url.URL
+	prefix  string
+	secret  []byte
+}
+
+// NewFSStorage storage in dir, created if missing. Presigned urls start with
+// baseURL, where the storage is expected to be served
+func NewFSStorage(dir, baseURL string, opts ...StorageOption) (*FSStorage, error) {
+	o := newStorageOptions(opts)
+	if err := os.MkdirAll(filepath.Join(dir, fsMetaDir), 0755); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := []byte(o.secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+
+	return &FSStorage{dir: dir, baseURL: u, prefix: o.prefix, secret: secret}, nil
+}
+
+// key storage key of key, with the prefix
+func (st *FSStorage) key(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	// cleaned first, keys can't escape the prefix
+	if key = st.prefix + key; key == fsMetaDir || strings.HasPrefix(key, fsMetaDir+"/") {
+		return "", errBadKey
+	}
+
+	return key, nil
+}
+
+func (st *FSStorage) path(key string) string {
+	return filepath.Join(st.dir, filepath.FromSlash(key))
+}
+
+func (st *FSStorage) metaPath(key string) string {
+	return filepath.Join(st.dir, fsMetaDir, filepath.FromSlash(key)+".json")
+}
+
+type fsMeta struct {
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag"`
+}
+
+// info of the stored key, the metadata is optional
+func (st *FSStorage) info(key string, fi fs.FileInfo) *ObjectInfo {
+	info := &ObjectInfo{
+		Key:          strings.TrimPrefix(key, st.prefix),
+		Size:         fi.Size(),
+		LastModified: fi.ModTime(),
+	}
+
+	var meta fsMeta
+	if data, err := os.ReadFile(st.metaPath(key)); err == nil && json.Unmarshal(data, &meta) == nil {
+		info.ContentType, info.ETag = meta.ContentType, meta.ETag
+	}
+
+	if info.ContentType == "" {
+		info.ContentType = mime.TypeByExtension(path.Ext(key))
+	}
+
+	return info
+}
+
+// writeFile write r to name through a temporary file, readers never see a
+// partial object
+func writeFile(name string, r io.Reader, w io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(io.MultiWriter(f, w), r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// Put implement Storage
+func (st *FSStorage) Put(ctx context.Context, key string, r io.Reader, opts ...PutOption) (*ObjectInfo, error) {
+	k, err := st.key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	o := newPutOptions(opts)
+	if o.contentType == "" {
+		if o.contentType, r, err = detectContentType(k, r); err != nil {
+			return nil, err
+		}
+	}
+
+	h := md5.New()
+	if err := writeFile(st.path(k), &ctxReader{ctx: ctx, r: r}, h); err != nil {
+		return nil, err
+	}
+
+	meta, _ := json.Marshal(fsMeta{ContentType: o.contentType, ETag: hex.EncodeToString(h.Sum(nil))})
+	if err := writeFile(st.metaPath(k), strings.NewReader(string(meta)), io.Discard); err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(st.path(k))
+	if err != nil {
+		return nil, err
+	}
+
+	return st.info(k, fi), nil
+}
+
+// Get implement Storage
+func (st *FSStorage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	k, err := st.key(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(st.path(k))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	if fi.IsDir() {
+		f.Close()
+		return nil, nil, ErrNotFound
+	}
+
+	return f, st.info(k, fi), nil
+}
+
+// Delete implement Storage
+func (st *FSStorage) Delete(ctx context.Context, key string) error {
+	k, err := st.key(key)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{st.path(k), st.metaPath(k)} {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// List implement Storage, only the directory of prefix is walked
+func (st *FSStorage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	if err := checkPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	full := st.prefix + prefix
+	if full == fsMetaDir || strings.HasPrefix(full, fsMetaDir+"/") {
+		return nil, errBadKey
+	}
+
+	root := st.dir
+	if idx := strings.LastIndex(full, "/"); idx >= 0 {
+		root = filepath.Join(st.dir, filepath.FromSlash(full[:idx]))
+	}
+
+	var objects []*ObjectInfo
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == root && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+
+			return err
+		}
+
+		rel, _ := filepath.Rel(st.dir, name)
+		key := filepath.ToSlash(rel)
+		switch {
+		case d.IsDir() && key == fsMetaDir:
+			return filepath.SkipDir
+		case d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-"):
+			return nil
+		case !strings.HasPrefix(key, full):
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		objects = append(objects, st.info(key, fi))
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// walked in the order of the path elements, "a/b" before "a.txt"
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return objects, nil
+}
+
+// sign signature of a presigned request
+func (st *FSStorage) sign(method, key string, expires int64, contentType string) string {
+	mac := hmac.New(sha256.New, st.secret)
+	mac.Write([]byte(method + "\n" + key + "\n" + strconv.FormatInt(expires, 10) + "\n" + contentType))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (st *FSStorage) presign(method, key string, ttl time.Duration, contentType string) (string, error) {
+	k, err := st.key(key)
+	if err != nil {
+		return "", err
+	}
+
+	expires := time.Now().Add(ttl).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", st.sign(method, k, expires, contentType))
+
+	u := *st.baseURL
+	u.Path = path.Join("/", u.Path, k)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// PresignGet implement Storage, the url is served by ServeHTTP
+func (st *FSStorage) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	return st.presign(http.MethodGet, key, ttl, "")
+}
+
+// PresignPut implement Storage, the url is served by ServeHTTP
+func (st *FSStorage) PresignPut(ctx context.Context, key string, ttl time.Duration, contentType string) (string, error) {
+	return st.presign(http.MethodPut, key, ttl, contentType)
+}
+
+// ServeHTTP serve presigned urls, mounted at the path of the base url
+func (st *FSStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := cleanKey(strings.TrimPrefix(r.URL.Path, st.baseURL.Path))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	q := r.URL.Query()
+	expires, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+	contentType := ""
+	if r.Method == http.MethodPut {
+		contentType = r.Header.Get("Content-Type")
+	}
+
+	signed := func(contentType string) bool {
+		return hmac.Equal([]byte(q.Get("signature")), []byte(st.sign(r.Method, key, expires, contentType)))
+	}
+
+	// a put presigned without a content type accepts any
+	if time.Now().Unix() > expires || !(signed(contentType) || (contentType != "" && signed(""))) {
+		http.Error(w, "invalid or expired signature", http.StatusForbidden)
+		return
+	}
+
+	// the signed key carries the prefix
+	key = strings.TrimPrefix(key, st.prefix)
+	switch r.Method {
+	case http.MethodGet:
+		body, info, err := st.Get(r.Context(), key)
+		if err == ErrNotFound {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		http.ServeContent(w, r, "", info.LastModified, body.(io.ReadSeeker))
+	case http.MethodPut:
+		var opts []PutOption
+		if contentType != "" {
+			opts = append(opts, WithContentType(contentType))
+		}
+
+		info, err := st.Put(r.Context(), key, r.Body, opts...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("ETag", `"`+info.ETag+`"`)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// ctxReader stop reading once ctx is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return c.r.Read(p)
+}
@@ -0,0 +1,131 @@
+package session
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// HealthTimeout timeout of every backend check
+var HealthTimeout = 3 * time.Second
+
+// BackendHealth health of a backend
+type BackendHealth struct {
+	Name      string        `json:"name"`
+	Healthy   bool          `json:"healthy"`
+	Latency   time.Duration `json:"-"`
+	LatencyMS int64         `json:"latency_ms"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Health health of every configured backend
+type Health struct {
+	Healthy  bool            `json:"healthy"`
+	Backends []BackendHealth `json:"backends"`
+}
+
+type healthCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func (s *Session) healthChecks() []healthCheck {
+	var checks []healthCheck
+	b := s.backends
+	if b == nil {
+		return nil
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, db := range b.dbs {
+		replicas := map[*namedPool]*replica{}
+		if rs := db.replicas; rs != nil {
+			for _, r := range rs.replicas {
+				replicas[r.namedPool] = r
+			}
+		}
+
+		for _, p := range db.pools {
+			check := p.PingContext
+			if r, ok := replicas[p]; ok {
+				check = r.healthCheck(db.replicas.maxLag)
+			}
+
+			checks = append(checks, healthCheck{p.name, check})
+		}
+	}
+
+	for name, c := range b.redises {
+		c := c
+		checks = append(checks, healthCheck{backendName("redis", name, b.defaultRedis), func(ctx context.Context) error {
+			return c.Ping(ctx).Err()
+		}})
+	}
+
+	sort.Slice(checks, func(i, j int) bool {
+		return checks[i].name < checks[j].name
+	})
+
+	if a := b.awsCfg; a != nil && a.Credentials != nil {
+		checks = append(checks, healthCheck{"aws", func(ctx context.Context) error {
+			_, err := a.Credentials.Retrieve(ctx)
+			return err
+		}})
+	}
+
+	return checks
+}
+
+// Health ping every backend concurrently, each with HealthTimeout
+func (s *Session) Health(ctx context.Context) *Health {
+	checks := s.healthChecks()
+	h := &Health{
+		Healthy:  true,
+		Backends: make([]BackendHealth, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for idx, c := range checks {
+		wg.Add(1)
+		go func(idx int, c healthCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := c.check(ctx)
+			latency := time.Since(start)
+
+			b := BackendHealth{
+				Name:      c.name,
+				Healthy:   err == nil,
+				Latency:   latency,
+				LatencyMS: latency.Milliseconds(),
+			}
+
+			if err != nil {
+				b.Error = err.Error()
+			}
+
+			h.Backends[idx] = b
+		}(idx, c)
+	}
+
+	wg.Wait()
+
+	for _, b := range h.Backends {
+		h.Healthy = h.Healthy && b.Healthy
+	}
+
+	return h
+}
+
+// HealthCheck implement gin_helper.HealthChecker
+func (s *Session) HealthCheck(ctx context.Context) (bool, interface{}) {
+	h := s.Health(ctx)
+	return h.Healthy, h
+}
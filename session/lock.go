@@ -0,0 +1,266 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+	uuid "github.com/gofrs/uuid"
+)
+
+var (
+	// ErrLockNotObtained the lock is held by someone else
+	ErrLockNotObtained = errors.New("session: lock not obtained")
+	// ErrLockNotHeld the lock expired or is held by someone else
+	ErrLockNotHeld = errors.New("session: lock not held")
+)
+
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+var extendScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// tryLock set key to a random token if it doesn't exist
+func tryLock(ctx context.Context, client redis.UniversalClient, key string, ttl time.Duration) (token string, ok bool, err error) {
+	token = uuid.Must(uuid.NewV4()).String()
+	ok, err = client.SetNX(ctx, key, token, ttl).Result()
+	return token, ok, err
+}
+
+// releaseLock delete key if it still holds token
+func releaseLock(ctx context.Context, client redis.UniversalClient, key, token string) (bool, error) {
+	n, err := releaseScript.Run(ctx, client, []string{key}, token).Int()
+	return n == 1, err
+}
+
+type lockOptions struct {
+	attempts  int
+	backoff   time.Duration
+	keepAlive bool
+}
+
+// LockOption option of Session.Lock
+type LockOption func(*lockOptions)
+
+// WithLockRetry retry acquiring up to attempts times, 0 is until the session
+// context is done. The jittered wait starts from backoff and doubles, up to 1s
+func WithLockRetry(attempts int, backoff time.Duration) LockOption {
+	return func(o *lockOptions) {
+		o.attempts = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithKeepAlive extend the lock every third of its ttl until released,
+// Lost is closed if it can't be
+func WithKeepAlive() LockOption {
+	return func(o *lockOptions) {
+		o.keepAlive = true
+	}
+}
+
+// Lock distributed lock on redis
+type Lock struct {
+	client redis.UniversalClient
+	key    string
+	token  string
+	ttl    time.Duration
+
+	lost     chan struct{}
+	lostOnce sync.Once
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// wait between attempts, from minLockBackoff up to maxLockBackoff
+const (
+	minLockBackoff = 10 * time.Millisecond
+	maxLockBackoff = time.Second
+)
+
+// minLockTTL redis expires keys in milliseconds, a shorter ttl would make a
+// lock without expiration
+const minLockTTL = time.Millisecond
+
+func checkLockTTL(ttl time.Duration) error {
+	if ttl < minLockTTL {
+		return fmt.Errorf("session: lock ttl %s is shorter than %s", ttl, minLockTTL)
+	}
+
+	return nil
+}
+
+// Lock acquire the lock on key of the default redis for ttl, at least 1ms,
+// with the session context. ErrLockNotObtained if it is held by someone else
+func (s *Session) Lock(key string, ttl time.Duration, opts ...LockOption) (*Lock, error) {
+	if err := checkLockTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	client := s.Redis()
+	if client == nil {
+		return nil, errors.New("session: redis is not configured")
+	}
+
+	o := &lockOptions{attempts: 1}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	ctx := s.Context()
+	backoff := o.backoff
+	if backoff < minLockBackoff {
+		backoff = minLockBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		token, ok, err := tryLock(ctx, client, key, ttl)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			l := &Lock{
+				client: client,
+				key:    key,
+				token:  token,
+				ttl:    ttl,
+				lost:   make(chan struct{}),
+				stop:   make(chan struct{}),
+				done:   make(chan struct{}),
+			}
+
+			if o.keepAlive {
+				go l.keepAlive()
+			} else {
+				close(l.done)
+			}
+
+			return l, nil
+		}
+
+		if o.attempts > 0 && attempt >= o.attempts {
+			return nil, ErrLockNotObtained
+		}
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if backoff *= 2; backoff > maxLockBackoff {
+			backoff = maxLockBackoff
+		}
+	}
+}
+
+// Key key of the lock
+func (l *Lock) Key() string {
+	return l.key
+}
+
+// Token random value identifying the holder
+func (l *Lock) Token() string {
+	return l.token
+}
+
+// Lost closed once the lock is found expired or taken over
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+func (l *Lock) markLost() {
+	l.lostOnce.Do(func() {
+		close(l.lost)
+	})
+}
+
+// Extend reset the ttl of the lock, ErrLockNotHeld if it isn't held anymore
+func (l *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	if err := checkLockTTL(ttl); err != nil {
+		return err
+	}
+
+	n, err := extendScript.Run(ctx, l.client, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+
+	if n != 1 {
+		l.markLost()
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// Release stop the keep alive and delete the lock if it is still held,
+// ErrLockNotHeld if it isn't
+func (l *Lock) Release(ctx context.Context) error {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+	<-l.done
+
+	ok, err := releaseLock(ctx, l.client, l.key, l.token)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		l.markLost()
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// keepAlive extend the lock until released, it is lost if not extended
+// within its ttl
+func (l *Lock) keepAlive() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	extended := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-l.lost:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+		err := l.Extend(ctx, l.ttl)
+		cancel()
+
+		switch {
+		case err == nil:
+			extended = time.Now()
+		case errors.Is(err, ErrLockNotHeld):
+			return
+		case time.Since(extended) >= l.ttl:
+			l.markLost()
+			return
+		}
+	}
+}
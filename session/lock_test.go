@@ -0,0 +1,87 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newRedisSession(t, mr)
+	ctx := context.Background()
+
+	l, err := s.Lock("job", time.Second)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, l.Token(), mustGet(t, mr, "job"))
+
+	_, err = s.Lock("job", time.Second)
+	assert.Equal(t, ErrLockNotObtained, err)
+
+	_, err = s.Lock("short", 0, WithKeepAlive())
+	assert.EqualError(t, err, "session: lock ttl 0s is shorter than 1ms")
+	assert.NotNil(t, l.Extend(ctx, time.Microsecond))
+
+	// acquired once released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.Nil(t, l.Release(ctx))
+	}()
+	l2, err := s.Lock("job", time.Second, WithLockRetry(0, 20*time.Millisecond))
+	assert.Nil(t, err)
+
+	// gives up when the context is done
+	c, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = s.WithContext(c).Lock("job", time.Second, WithLockRetry(0, 10*time.Millisecond))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the token is checked before extending or deleting
+	assert.Nil(t, l2.Extend(ctx, time.Minute))
+	assert.Equal(t, time.Minute, mr.TTL("job"))
+	mr.Set("job", "other")
+	assert.Equal(t, ErrLockNotHeld, l2.Extend(ctx, time.Minute))
+	assert.Equal(t, ErrLockNotHeld, l2.Release(ctx))
+	assert.Equal(t, "other", mustGet(t, mr, "job"))
+	select {
+	case <-l2.Lost():
+	default:
+		t.Error("lost isn't closed")
+	}
+}
+
+func TestLockKeepAlive(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newRedisSession(t, mr)
+
+	l, err := s.Lock("keep", 300*time.Millisecond, WithKeepAlive())
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	mr.SetTTL("keep", 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return mr.TTL("keep") > 100*time.Millisecond
+	}, time.Second, 10*time.Millisecond)
+
+	mr.Del("keep")
+	select {
+	case <-l.Lost():
+	case <-time.After(time.Second):
+		t.Error("lost isn't closed")
+	}
+
+	assert.Equal(t, ErrLockNotHeld, l.Release(context.Background()))
+}
+
+func mustGet(t *testing.T, mr *miniredis.Miniredis, key string) string {
+	v, err := mr.Get(key)
+	assert.Nil(t, err)
+	return v
+}
@@ -0,0 +1,348 @@
+package session
+
+import (
+	"container/heap"
+	"log"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/spf13/viper"
+)
+
+const (
+	EvictionLRU = "lru"
+	EvictionLFU = "lfu"
+)
+
+// MemoryStats counters of a memory store
+type MemoryStats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Entries   int64 `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	// Rejected values not kept as they can't be sized under max_bytes
+	Rejected int64 `json:"rejected"`
+}
+
+// MemoryStore in process cache behind Session.Memory, plug in another one
+// with WithMemoryStore
+type MemoryStore interface {
+	Get(key string) (interface{}, bool)
+	// Set keep v for ttl, the default ttl if ttl <= 0
+	Set(key string, v interface{}, ttl time.Duration)
+	Delete(key string)
+	Stats() MemoryStats
+}
+
+// Sizer value reporting its size in bytes, counted against max_bytes
+type Sizer interface {
+	Size() int
+}
+
+// sizeOf bytes of v, by fn for the values that are neither a Sizer, a
+// []byte nor a string. false if v can't be sized
+func sizeOf(v interface{}, fn func(v interface{}) int) (int64, bool) {
+	switch val := v.(type) {
+	case Sizer:
+		return int64(val.Size()), true
+	case []byte:
+		return int64(len(val)), true
+	case string:
+		return int64(len(val)), true
+	}
+
+	if fn != nil {
+		return int64(fn(v)), true
+	}
+
+	return 0, false
+}
+
+// openMemory go-cache of MemoryCache and the store of Memory, which is the
+// go-cache too unless the memory section bounds it
+func openMemory(v *viper.Viper, o *options) (*cache.Cache, MemoryStore) {
+	// validated by validateConfig
+	cfg, _ := parseMemoryConfig(v)
+	c := cache.New(cfg.DefaultTTL, cfg.CleanupInterval)
+
+	switch {
+	case o.memory != nil:
+		return c, o.memory
+	case cfg.MaxEntries > 0 || cfg.MaxBytes > 0:
+		store := newBoundedStore(cfg)
+		store.sizer = o.memorySizer
+		return c, store
+	default:
+		return c, &goCacheStore{c: c}
+	}
+}
+
+// goCacheStore unbounded store on go-cache, shared with MemoryCache
+type goCacheStore struct {
+	c      *cache.Cache
+	hits   int64
+	misses int64
+}
+
+func (s *goCacheStore) Get(key string) (interface{}, bool) {
+	v, ok := s.c.Get(key)
+	if ok {
+		atomic.AddInt64(&s.hits, 1)
+	} else {
+		atomic.AddInt64(&s.misses, 1)
+	}
+
+	return v, ok
+}
+
+func (s *goCacheStore) Set(key string, v interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = cache.DefaultExpiration
+	}
+
+	s.c.Set(key, v, ttl)
+}
+
+func (s *goCacheStore) Delete(key string) {
+	s.c.Delete(key)
+}
+
+func (s *goCacheStore) Stats() MemoryStats {
+	return MemoryStats{
+		Hits:    atomic.LoadInt64(&s.hits),
+		Misses:  atomic.LoadInt64(&s.misses),
+		Entries: int64(s.c.ItemCount()),
+	}
+}
+
+// memoryItem entry of a boundedStore
+type memoryItem struct {
+	key    string
+	value  interface{}
+	size   int64
+	expire time.Time
+	// freq & tick order the eviction, index is the position in the heap
+	freq  int64
+	tick  int64
+	index int
+}
+
+// memoryHeap items in eviction order, the first is evicted first
+type memoryHeap struct {
+	items []*memoryItem
+	lfu   bool
+}
+
+func (h *memoryHeap) Len() int { return len(h.items) }
+
+func (h *memoryHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.lfu && a.freq != b.freq {
+		return a.freq < b.freq
+	}
+
+	return a.tick < b.tick
+}
+
+func (h *memoryHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.items[i].index = i
+	h.items[j].index = j
+}
+
+func (h *memoryHeap) Push(x interface{}) {
+	item := x.(*memoryItem)
+	item.index = len(h.items)
+	h.items = append(h.items, item)
+}
+
+func (h *memoryHeap) Pop() interface{} {
+	n := len(h.items)
+	item := h.items[n-1]
+	h.items[n-1] = nil
+	h.items = h.items[:n-1]
+	return item
+}
+
+// boundedStore store bounded by entries and bytes, evicting the least
+// recently or the least frequently used items
+type boundedStore struct {
+	mu         sync.Mutex
+	items      map[string]*memoryItem
+	heap       memoryHeap
+	tick       int64
+	ttl        time.Duration
+	maxEntries int64
+	maxBytes   int64
+	stats      MemoryStats
+	stop       chan struct{}
+	stopOnce   sync.Once
+
+	// sizer size of the values sizeOf can't tell, see WithMemorySizer
+	sizer func(v interface{}) int
+	// unsized types already logged as rejected
+	unsized sync.Map
+}
+
+func newBoundedStore(cfg *memoryConfig) *boundedStore {
+	s := &boundedStore{
+		items:      make(map[string]*memoryItem),
+		heap:       memoryHeap{lfu: cfg.Eviction == EvictionLFU},
+		ttl:        cfg.DefaultTTL,
+		maxEntries: int64(cfg.MaxEntries),
+		maxBytes:   int64(cfg.MaxBytes),
+		stop:       make(chan struct{}),
+	}
+
+	if cfg.CleanupInterval > 0 {
+		go s.cleanup(cfg.CleanupInterval)
+	}
+
+	return s
+}
+
+func (s *boundedStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for _, item := range s.items {
+				if s.expired(item, now) {
+					s.remove(item)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close stop the cleanup
+func (s *boundedStore) Close() error {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+
+	return nil
+}
+
+func (s *boundedStore) expired(item *memoryItem, now time.Time) bool {
+	return !item.expire.IsZero() && now.After(item.expire)
+}
+
+func (s *boundedStore) remove(item *memoryItem) {
+	heap.Remove(&s.heap, item.index)
+	delete(s.items, item.key)
+	s.stats.Entries--
+	s.stats.Bytes -= item.size
+}
+
+func (s *boundedStore) touch(item *memoryItem) {
+	s.tick++
+	item.tick = s.tick
+	item.freq++
+}
+
+func (s *boundedStore) Get(key string) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if ok && s.expired(item, time.Now()) {
+		s.remove(item)
+		ok = false
+	}
+
+	if !ok {
+		s.stats.Misses++
+		return nil, false
+	}
+
+	s.stats.Hits++
+	s.touch(item)
+	heap.Fix(&s.heap, item.index)
+	return item.value, true
+}
+
+func (s *boundedStore) Set(key string, v interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+
+	size, ok := sizeOf(v, s.sizer)
+	if !ok && s.maxBytes > 0 {
+		// an unsized value would escape max_bytes
+		s.reject(key, v)
+		return
+	}
+
+	item := &memoryItem{key: key, value: v, size: size}
+	if ttl > 0 {
+		item.expire = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if old, ok := s.items[key]; ok {
+		// an overwrite keeps the use count
+		item.freq = old.freq
+		s.remove(old)
+	}
+
+	s.touch(item)
+	heap.Push(&s.heap, item)
+	s.items[key] = item
+	s.stats.Entries++
+	s.stats.Bytes += item.size
+
+	for s.heap.Len() > 1 && s.overflow() {
+		s.remove(s.heap.items[0])
+		s.stats.Evictions++
+	}
+}
+
+// reject drop key, v can't be counted against max_bytes
+func (s *boundedStore) reject(key string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if _, logged := s.unsized.LoadOrStore(t, true); !logged {
+		log.Printf("session: memory: %v values can't be sized, they aren't kept under max_bytes, see Sizer & WithMemorySizer", t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the previous value is stale
+	if item, ok := s.items[key]; ok {
+		s.remove(item)
+	}
+	s.stats.Rejected++
+}
+
+func (s *boundedStore) overflow() bool {
+	return (s.maxEntries > 0 && s.stats.Entries > s.maxEntries) ||
+		(s.maxBytes > 0 && s.stats.Bytes > s.maxBytes)
+}
+
+func (s *boundedStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if item, ok := s.items[key]; ok {
+		s.remove(item)
+	}
+}
+
+func (s *boundedStore) Stats() MemoryStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
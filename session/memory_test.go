@@ -0,0 +1,88 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoundedStore(t *testing.T) {
+	lru := newBoundedStore(&memoryConfig{MaxEntries: 2, Eviction: EvictionLRU})
+	defer lru.Close()
+
+	lru.Set("a", 1, 0)
+	lru.Set("b", 2, 0)
+	lru.Get("a")
+	lru.Set("c", 3, 0)
+	_, ok := lru.Get("b")
+	assert.False(t, ok)
+	_, ok = lru.Get("a")
+	assert.True(t, ok)
+
+	lfu := newBoundedStore(&memoryConfig{MaxEntries: 2, Eviction: EvictionLFU})
+	defer lfu.Close()
+
+	lfu.Set("a", 1, 0)
+	lfu.Get("a")
+	lfu.Set("b", 2, 0)
+	lfu.Set("c", 3, 0)
+	_, ok = lfu.Get("b")
+	assert.False(t, ok)
+	_, ok = lfu.Get("a")
+	assert.True(t, ok)
+
+	bytes := newBoundedStore(&memoryConfig{MaxBytes: 10})
+	defer bytes.Close()
+
+	bytes.Set("a", "12345", 0)
+	bytes.Set("b", []byte("123456"), 0)
+	bytes.Set("c", "1", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	_, ok = bytes.Get("c")
+	assert.False(t, ok)
+
+	// values that can't be sized aren't kept
+	bytes.Set("d", 1, 0)
+	_, ok = bytes.Get("d")
+	assert.False(t, ok)
+
+	stats := bytes.Stats()
+	assert.Equal(t, MemoryStats{Misses: 2, Evictions: 1, Entries: 1, Bytes: 6, Rejected: 1}, stats)
+
+	sized := newBoundedStore(&memoryConfig{MaxBytes: 10})
+	defer sized.Close()
+	sized.sizer = func(interface{}) int { return 8 }
+	sized.Set("a", 1, 0)
+	sized.Set("b", 2, 0)
+	_, ok = sized.Get("b")
+	assert.True(t, ok)
+	assert.EqualValues(t, 8, sized.Stats().Bytes)
+}
+
+func TestMemoryConfig(t *testing.T) {
+	s, err := New([]byte(`
+memory:
+  default_ttl: 1m
+  max_entries: 100
+  eviction: lfu
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	assert.IsType(t, &boundedStore{}, s.Memory())
+	s.Memory().Set("k", "v", 0)
+	_, ok := s.Memory().Get("k")
+	assert.True(t, ok)
+	assert.EqualValues(t, 1, s.MemoryStats().Hits)
+
+	_, err = New([]byte("memory:\n  eviction: fifo\n"))
+	assert.IsType(t, &ConfigError{}, err)
+
+	store := &goCacheStore{c: s.MemoryCache()}
+	s, err = New(nil, WithMemoryStore(store))
+	assert.Nil(t, err)
+	assert.Equal(t, store, s.Memory())
+}
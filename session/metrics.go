@@ -0,0 +1,84 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// TxStats transaction retries since start
+type TxStats struct {
+	// Retries transactions run again
+	Retries int64 `json:"retries"`
+	// Deadlocks & LockTimeouts failures causing the retries
+	Deadlocks    int64 `json:"deadlocks"`
+	LockTimeouts int64 `json:"lock_timeouts"`
+	// Exhausted transactions still failing after the last attempt
+	Exhausted int64 `json:"exhausted"`
+}
+
+var (
+	txStats TxStats
+
+	txMetricsOnce sync.Once
+	txRetries     metric.Int64Counter
+	txExhausted   metric.Int64Counter
+)
+
+// TxRetryStats snapshot of the transaction retries
+func TxRetryStats() TxStats {
+	return TxStats{
+		Retries:      atomic.LoadInt64(&txStats.Retries),
+		Deadlocks:    atomic.LoadInt64(&txStats.Deadlocks),
+		LockTimeouts: atomic.LoadInt64(&txStats.LockTimeouts),
+		Exhausted:    atomic.LoadInt64(&txStats.Exhausted),
+	}
+}
+
+func txMetrics() {
+	txMetricsOnce.Do(func() {
+		meter := otel.Meter(tracerName)
+		txRetries, _ = meter.Int64Counter("session.tx.retries",
+			metric.WithDescription("transactions retried after a deadlock or a lock wait timeout"))
+		txExhausted, _ = meter.Int64Counter("session.tx.retries_exhausted",
+			metric.WithDescription("transactions failed after the last attempt"))
+	})
+}
+
+func retryReason(err error) string {
+	if IsDeadlock(err) {
+		return "deadlock"
+	}
+
+	return "lock_timeout"
+}
+
+// recordTxRetry count a retry, or the give up after the last attempt
+func recordTxRetry(ctx context.Context, err error, exhausted bool) {
+	txMetrics()
+	reason := retryReason(err)
+	attrs := metric.WithAttributes(attribute.String("reason", reason))
+
+	if exhausted {
+		atomic.AddInt64(&txStats.Exhausted, 1)
+		if txExhausted != nil {
+			txExhausted.Add(ctx, 1, attrs)
+		}
+		return
+	}
+
+	atomic.AddInt64(&txStats.Retries, 1)
+	if reason == "deadlock" {
+		atomic.AddInt64(&txStats.Deadlocks, 1)
+	} else {
+		atomic.AddInt64(&txStats.LockTimeouts, 1)
+	}
+
+	if txRetries != nil {
+		txRetries.Add(ctx, 1, attrs)
+	}
+}
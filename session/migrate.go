@@ -0,0 +1,404 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+)
+
+// MigrationTable history table of the applied migrations
+var MigrationTable = "schema_migrations"
+
+// migrationLock name of the advisory lock held while migrating
+const migrationLock = "session:migrate"
+
+var (
+	// ErrNoDB the session has no db
+	ErrNoDB = errors.New("session: db is not configured")
+	// ErrIrreversible the migration has no down
+	ErrIrreversible = errors.New("session: migration is irreversible")
+	// ErrUnregistered the latest applied migration isn't registered, Down &
+	// Redo would skip it
+	ErrUnregistered = errors.New("session: migration is not registered")
+)
+
+// Migration versioned schema change, Down is nil if it can't be reverted
+type Migration struct {
+	Version int64
+	Name    string
+	Up      SetdbFunc
+	Down    SetdbFunc
+}
+
+var migrations = map[int64]*Migration{}
+
+func registerMigration(m *Migration) error {
+	if m.Up == nil {
+		return fmt.Errorf("session: migration %d has no up", m.Version)
+	}
+
+	if _, ok := migrations[m.Version]; ok {
+		return fmt.Errorf("session: duplicated migration %d", m.Version)
+	}
+
+	migrations[m.Version] = m
+	return nil
+}
+
+// RegisterMigration register a migration applied by Setdb & Migrator,
+// panics if the version is registered
+func RegisterMigration(version int64, name string, up, down SetdbFunc) {
+	if err := registerMigration(&Migration{Version: version, Name: name, Up: up, Down: down}); err != nil {
+		panic(err)
+	}
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// RegisterMigrationFS register sql migrations in the root of fsys, named like
+// 0001_create_users.up.sql & 0001_create_users.down.sql. Statements end with
+// a ; at the end of a line
+func RegisterMigrationFS(fsys fs.FS) error {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return err
+	}
+
+	found := map[int64]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("session: migration %s: %w", entry.Name(), err)
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return err
+		}
+
+		m := found[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			found[version] = m
+		}
+
+		if match[3] == "up" {
+			m.Up = sqlMigration(string(data))
+		} else {
+			m.Down = sqlMigration(string(data))
+		}
+	}
+
+	for _, m := range found {
+		if err := registerMigration(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitStatements split a sql script at the lines ending with ;
+func splitStatements(script string) []string {
+	var (
+		stmts []string
+		b     strings.Builder
+	)
+
+	flush := func() {
+		if stmt := strings.TrimSpace(b.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		b.Reset()
+	}
+
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+
+		b.WriteString(line)
+		b.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			flush()
+		}
+	}
+
+	flush()
+	return stmts
+}
+
+func sqlMigration(script string) SetdbFunc {
+	stmts := splitStatements(script)
+	return func(db *gorm.DB) error {
+		for _, stmt := range stmts {
+			if err := db.Exec(stmt).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// schemaMigration row of MigrationTable
+type schemaMigration struct {
+	Version   int64  `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+// MigrationStatus status of a migration, applied versions no longer
+// registered are listed too
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+// Migrator run the registered migrations on the db of a session, every
+// operation holds an advisory lock so that concurrent replicas don't race
+type Migrator struct {
+	s          *Session
+	migrations []*Migration
+}
+
+// NewMigrator migrator on the db selected by s, see Session.DB
+func NewMigrator(s *Session) *Migrator {
+	m := &Migrator{s: s}
+	for _, mig := range migrations {
+		m.migrations = append(m.migrations, mig)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	return m
+}
+
+// withLock run fn holding the migration lock, MigrationTable is created if
+// missing. The lock is held by a connection of the write pool, fn runs on that
+// same connection so that a pool of one connection doesn't deadlock
+func (m *Migrator) withLock(fn func(db *gorm.DB) error) error {
+	inst := m.s.instance()
+	if inst == nil {
+		return ErrNoDB
+	}
+
+	ctx := m.s.Context()
+	db := m.s.withContext(inst.write)
+	if d := inst.dialect; d.lock != nil {
+		conn, err := inst.pools[0].Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if err := d.lock(ctx, conn, migrationLock); err != nil {
+			return err
+		}
+		defer d.unlock(context.Background(), conn, migrationLock)
+
+		// a gorm of its own, the read/write split of the instance would
+		// move statements off the pinned connection
+		lazy := m.s.opts != nil && m.s.opts.lazy
+		pinned, err := gorm.Open(d.gorm(conn, lazy), &gorm.Config{DisableAutomaticPing: true})
+		if err != nil {
+			return err
+		}
+
+		traceGorm(pinned)
+		db = pinned.WithContext(ctx)
+	}
+
+	if err := db.Table(MigrationTable).AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+
+	return fn(db)
+}
+
+func (m *Migrator) applied(db *gorm.DB) (map[int64]*schemaMigration, error) {
+	var rows []*schemaMigration
+	if err := db.Table(MigrationTable).Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]*schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	return applied, nil
+}
+
+// run apply or revert mig and record it, in a transaction if the dialect
+// can roll back schema changes
+func (m *Migrator) run(db *gorm.DB, mig *Migration, up bool) error {
+	fn := mig.Up
+	if !up {
+		if fn = mig.Down; fn == nil {
+			return fmt.Errorf("migration %d %s: %w", mig.Version, mig.Name, ErrIrreversible)
+		}
+	}
+
+	run := func(db *gorm.DB) error {
+		if err := fn(db); err != nil {
+			return fmt.Errorf("migration %d %s: %w", mig.Version, mig.Name, err)
+		}
+
+		if !up {
+			return db.Table(MigrationTable).Where("version = ?", mig.Version).Delete(&schemaMigration{}).Error
+		}
+
+		return db.Table(MigrationTable).Create(&schemaMigration{
+			Version:   mig.Version,
+			Name:      mig.Name,
+			AppliedAt: time.Now(),
+		}).Error
+	}
+
+	if inst := m.s.instance(); inst != nil && inst.dialect.transactionalDDL {
+		return db.Transaction(run)
+	}
+
+	return run(db)
+}
+
+func (m *Migrator) up(db *gorm.DB) error {
+	applied, err := m.applied(db)
+	if err != nil {
+		return err
+	}
+
+	for _, mig := range m.migrations {
+		if applied[mig.Version] != nil {
+			continue
+		}
+
+		if err := m.run(db, mig, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// last the latest applied migration, nil if none. It must be registered,
+// reverting an older one would leave the newer one applied on top of it
+func (m *Migrator) last(db *gorm.DB) (*Migration, error) {
+	applied, err := m.applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *schemaMigration
+	for _, row := range applied {
+		if latest == nil || row.Version > latest.Version {
+			latest = row
+		}
+	}
+
+	if latest == nil {
+		return nil, nil
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version == latest.Version {
+			return mig, nil
+		}
+	}
+
+	return nil, fmt.Errorf("migration %d %s: %w", latest.Version, latest.Name, ErrUnregistered)
+}
+
+// Status every registered or applied migration, ordered by version. It
+// only reads, neither the lock is taken nor MigrationTable created
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	inst := m.s.instance()
+	if inst == nil {
+		return nil, ErrNoDB
+	}
+
+	// applied versions are read from the writer, replicas may lag
+	db := m.s.withContext(inst.write).Clauses(dbresolver.Write)
+	applied := map[int64]*schemaMigration{}
+	if db.Migrator().HasTable(MigrationTable) {
+		var err error
+		if applied, err = m.applied(db); err != nil {
+			return nil, err
+		}
+	}
+
+	var list []MigrationStatus
+	for _, mig := range m.migrations {
+		st := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if row := applied[mig.Version]; row != nil {
+			st.Applied, st.AppliedAt = true, &row.AppliedAt
+			delete(applied, mig.Version)
+		}
+
+		list = append(list, st)
+	}
+
+	for _, row := range applied {
+		list = append(list, MigrationStatus{Version: row.Version, Name: row.Name, Applied: true, AppliedAt: &row.AppliedAt})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// Up apply every pending migration in version order
+func (m *Migrator) Up() error {
+	return m.withLock(m.up)
+}
+
+// Down revert the latest applied migration, ErrUnregistered if it isn't
+// registered
+func (m *Migrator) Down() error {
+	return m.withLock(func(db *gorm.DB) error {
+		mig, err := m.last(db)
+		if err != nil || mig == nil {
+			return err
+		}
+
+		return m.run(db, mig, false)
+	})
+}
+
+// Redo revert then apply the latest applied migration
+func (m *Migrator) Redo() error {
+	return m.withLock(func(db *gorm.DB) error {
+		mig, err := m.last(db)
+		if err != nil || mig == nil {
+			return err
+		}
+
+		if err := m.run(db, mig, false); err != nil {
+			return err
+		}
+
+		return m.run(db, mig, true)
+	})
+}
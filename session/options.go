@@ -0,0 +1,124 @@
+package session
+
+import (
+	"strings"
+	"time"
+)
+
+type options struct {
+	retries int
+	backoff time.Duration
+	lazy    bool
+
+	txRetries int
+	txBackoff time.Duration
+
+	memory      MemoryStore
+	memorySizer func(v interface{}) int
+
+	env         bool
+	envPrefix   string
+	interpolate bool
+}
+
+// Option option of Open
+type Option func(*options)
+
+// WithRetry retry connecting each backend up to attempts times,
+// the wait starts from backoff and doubles after every failure
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = attempts
+		o.backoff = backoff
+	}
+}
+
+// WithLazyConnect don't connect during Open, backends are dialed on first use
+func WithLazyConnect() Option {
+	return func(o *options) {
+		o.lazy = true
+	}
+}
+
+// WithTxRetry run a Transaction up to attempts times if it fails by a
+// deadlock or a lock wait timeout, the jittered wait starts from backoff
+// and doubles after every failure. Default is 3 attempts from 20ms
+func WithTxRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.txRetries = attempts
+		o.txBackoff = backoff
+	}
+}
+
+// WithMemoryStore use store as Session.Memory instead of the one configured
+// by the memory section
+func WithMemoryStore(store MemoryStore) Option {
+	return func(o *options) {
+		o.memory = store
+	}
+}
+
+// WithMemorySizer size in bytes of the values Memory can't size itself, ie
+// neither a Sizer, a []byte nor a string. Under max_bytes such values aren't
+// kept without it
+func WithMemorySizer(fn func(v interface{}) int) Option {
+	return func(o *options) {
+		o.memorySizer = fn
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{retries: 1, txRetries: 3, txBackoff: 20 * time.Millisecond}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+func (o *options) retry(fn func() error) (err error) {
+	backoff := o.backoff
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= o.retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// BackendError backend failed to open
+type BackendError struct {
+	Backend string
+	Err     error
+}
+
+func (err *BackendError) Error() string {
+	return "open " + err.Backend + ": " + err.Err.Error()
+}
+
+func (err *BackendError) Unwrap() error {
+	return err.Err
+}
+
+// OpenError every backend failed during Open
+type OpenError []*BackendError
+
+func (err OpenError) Error() string {
+	msgs := make([]string, len(err))
+	for idx, e := range err {
+		msgs[idx] = e.Error()
+	}
+
+	return "session: " + strings.Join(msgs, "; ")
+}
+
+func (err OpenError) Unwrap() []error {
+	errs := make([]error, len(err))
+	for idx, e := range err {
+		errs[idx] = e
+	}
+
+	return errs
+}
@@ -0,0 +1,216 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// replica read replica, taken out by the checker while down or lagging
+type replica struct {
+	*namedPool
+	// db standalone gorm on the replica pool, used by DBReadFresh
+	db *gorm.DB
+
+	mu       sync.RWMutex
+	down     bool
+	failures int
+	// lag replication lag, -1 if unknown
+	lag time.Duration
+	// lagErr error of the last lag query
+	lagErr error
+}
+
+func (r *replica) state() (down bool, lag time.Duration) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.down, r.lag
+}
+
+// healthCheck ping the replica, with max_replication_lag set a replica whose
+// lag can't be measured is reported too, reads skip it
+func (r *replica) healthCheck(maxLag time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := r.PingContext(ctx); err != nil {
+			return err
+		}
+
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		if maxLag > 0 && r.lagErr != nil {
+			return fmt.Errorf("replication lag unknown: %w", r.lagErr)
+		}
+
+		return nil
+	}
+}
+
+// replicaSet balance reads across replicas, implement dbresolver.Policy.
+// The write pool is registered as the last replica so that the policy is
+// always asked, it is picked only if no replica is available
+type replicaSet struct {
+	dialect     dialect
+	policy      string
+	maxLag      time.Duration
+	maxFailures int
+	write       *sql.DB
+	replicas    []*replica
+
+	next uint64
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// available replicas up and lagging at most maxLag, the lag must be known
+// unless maxLag is 0
+func (rs *replicaSet) available(maxLag time.Duration) []*replica {
+	var list []*replica
+	for _, r := range rs.replicas {
+		down, lag := r.state()
+		if down || (maxLag > 0 && (lag < 0 || lag > maxLag)) {
+			continue
+		}
+
+		list = append(list, r)
+	}
+
+	return list
+}
+
+func (rs *replicaSet) pick(list []*replica) *replica {
+	switch {
+	case len(list) == 0:
+		return nil
+	case len(list) == 1:
+		return list[0]
+	}
+
+	switch rs.policy {
+	case ReadPolicyLeastConn:
+		best := list[0]
+		for _, r := range list[1:] {
+			if r.Stats().InUse < best.Stats().InUse {
+				best = r
+			}
+		}
+
+		return best
+	case ReadPolicyRandom:
+		return list[rand.Intn(len(list))]
+	default:
+		n := atomic.AddUint64(&rs.next, 1)
+		return list[(n-1)%uint64(len(list))]
+	}
+}
+
+// Resolve implement dbresolver.Policy
+func (rs *replicaSet) Resolve([]gorm.ConnPool) gorm.ConnPool {
+	if r := rs.pick(rs.available(rs.maxLag)); r != nil {
+		return r.DB
+	}
+
+	return rs.write
+}
+
+// check ping every replica and measure its lag
+func (rs *replicaSet) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, r := range rs.replicas {
+		wg.Add(1)
+		go func(r *replica) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, HealthTimeout)
+			defer cancel()
+
+			err := r.PingContext(ctx)
+			lag := time.Duration(-1)
+			var lagErr error
+			if err == nil && rs.dialect.lag != nil {
+				if lag, lagErr = rs.dialect.lag(ctx, r.DB); lagErr != nil {
+					lag = -1
+				}
+			} else if err == nil {
+				lag = 0
+			}
+
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			// logged once per failure streak, the replica is out of
+			// DBReadFresh & of reads under max_replication_lag meanwhile
+			switch {
+			case lagErr != nil && r.lagErr == nil:
+				log.Printf("session: replica %s lag check failed: %v", r.name, lagErr)
+			case lagErr == nil && r.lagErr != nil && err == nil:
+				log.Printf("session: replica %s lag check is back", r.name)
+			}
+
+			if err == nil {
+				r.lagErr = lagErr
+			}
+
+			r.lag = lag
+			if err == nil {
+				if r.down {
+					log.Printf("session: replica %s is back", r.name)
+				}
+
+				r.down, r.failures = false, 0
+				return
+			}
+
+			r.failures++
+			if !r.down && r.failures >= rs.maxFailures {
+				log.Printf("session: replica %s is taken out: %v", r.name, err)
+				r.down = true
+			}
+		}(r)
+	}
+
+	wg.Wait()
+}
+
+// run check replicas every interval until close
+func (rs *replicaSet) run(interval time.Duration) {
+	rs.stop = make(chan struct{})
+	rs.wg.Add(1)
+	go func() {
+		defer rs.wg.Done()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-rs.stop
+			cancel()
+		}()
+
+		rs.check(ctx)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-rs.stop:
+				return
+			case <-ticker.C:
+				rs.check(ctx)
+			}
+		}
+	}()
+}
+
+func (rs *replicaSet) close() {
+	if rs.stop != nil {
+		close(rs.stop)
+		rs.wg.Wait()
+		rs.stop = nil
+	}
+}
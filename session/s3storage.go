@@ -0,0 +1,232 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Storage storage on an s3 bucket
+type S3Storage struct {
+	client   *s3.Client
+	presign  *s3.PresignClient
+	uploader *manager.Uploader
+	bucket   string
+	prefix   string
+}
+
+// NewS3Storage storage on bucket with cfg, eg Session.AWSConfig
+func NewS3Storage(cfg aws.Config, bucket string, opts ...StorageOption) *S3Storage {
+	o := newStorageOptions(opts)
+	client := s3.NewFromConfig(cfg, func(so *s3.Options) {
+		so.UsePathStyle = o.pathStyle
+	})
+
+	return &S3Storage{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+		uploader: manager.NewUploader(client, func(u *manager.Uploader) {
+			if o.partSize > 0 {
+				u.PartSize = o.partSize
+			}
+
+			if o.concurrency > 0 {
+				u.Concurrency = o.concurrency
+			}
+		}),
+		bucket: bucket,
+		prefix: o.prefix,
+	}
+}
+
+// Client s3 client of the storage
+func (st *S3Storage) Client() *s3.Client {
+	return st.client
+}
+
+func (st *S3Storage) key(key string) (string, error) {
+	key, err := cleanKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	return st.prefix + key, nil
+}
+
+// isS3NotFound missing object errors of GetObject & HeadObject
+func isS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
+
+// Put implement Storage, bodies larger than the part size are uploaded in
+// parts, the parts are aborted if it fails
+func (st *S3Storage) Put(ctx context.Context, key string, r io.Reader, opts ...PutOption) (*ObjectInfo, error) {
+	k, err := st.key(key)
+	if err != nil {
+		return nil, err
+	}
+
+	o := newPutOptions(opts)
+	if o.contentType == "" {
+		if o.contentType, r, err = detectContentType(key, r); err != nil {
+			return nil, err
+		}
+	}
+
+	body := &countingReader{r: r}
+	out, err := st.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(st.bucket),
+		Key:         aws.String(k),
+		Body:        body,
+		ContentType: aws.String(o.contentType),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ObjectInfo{
+		Key:          key,
+		Size:         body.n,
+		ContentType:  o.contentType,
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: time.Now(),
+	}, nil
+}
+
+// Get implement Storage
+func (st *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error) {
+	k, err := st.key(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := st.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(k),
+	})
+	if isS3NotFound(err) {
+		return nil, nil, ErrNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return out.Body, &ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// Delete implement Storage
+func (st *S3Storage) Delete(ctx context.Context, key string) error {
+	k, err := st.key(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = st.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(k),
+	})
+	return err
+}
+
+// List implement Storage, content types aren't listed by s3
+func (st *S3Storage) List(ctx context.Context, prefix string) ([]*ObjectInfo, error) {
+	if err := checkPrefix(prefix); err != nil {
+		return nil, err
+	}
+
+	var objects []*ObjectInfo
+	pages := s3.NewListObjectsV2Paginator(st.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(st.bucket),
+		Prefix: aws.String(st.prefix + prefix),
+	})
+
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Contents {
+			objects = append(objects, &ObjectInfo{
+				Key:          strings.TrimPrefix(aws.ToString(obj.Key), st.prefix),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+
+	return objects, nil
+}
+
+// PresignGet implement Storage
+func (st *S3Storage) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	k, err := st.key(key)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := st.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(k),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return req.URL, nil
+}
+
+// PresignPut implement Storage
+func (st *S3Storage) PresignPut(ctx context.Context, key string, ttl time.Duration, contentType string) (string, error) {
+	k, err := st.key(key)
+	if err != nil {
+		return "", err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(st.bucket),
+		Key:    aws.String(k),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	req, err := st.presign.PresignPutObject(ctx, input, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", err
+	}
+
+	return req.URL, nil
+}
+
+// countingReader count the bytes read
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -6,14 +6,14 @@ import (
 	"database/sql"
 	"errors"
 	"io"
+	"log"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/go-redis/redis"
-	"github.com/jinzhu/gorm"
-	"github.com/mitchellh/mapstructure"
 	"github.com/patrickmn/go-cache"
 	"github.com/spf13/viper"
+	"gorm.io/gorm"
 )
 
 // ErrNotFound err not found
@@ -22,166 +22,357 @@ var ErrNotFound = errors.New("session: nil")
 // Session session
 type Session struct {
 	// db
-	memory     *cache.Cache
-	redis      *redis.Client
-	mysqlRead  *gorm.DB
-	mysqlWrite *gorm.DB
-
-	aws *session.Session
-
-	// shared configuration
-	v *viper.Viper
+	memory *cache.Cache
+	store  MemoryStore
+	// dbName instance selected by DB, the default one if empty
+	dbName string
+	// dbWrite transaction begun by DBBegin or Transaction, and its state
+	dbWrite *gorm.DB
+	tx      *txState
+	// readOnWrite read on db write
+	readOnWrite bool
+
+	// every named instance, resolved on use so that reloads swap them
+	backends *backends
+
+	// prefix key of the config set by SubViper, the config itself is held by
+	// backends as reloads replace it
+	prefix string
+	opts   *options
 
 	// context
 	ctx context.Context
 }
 
 // New new session
-func New(data []byte) (*Session, error) {
-	return NewWithReader(bytes.NewReader(data))
+func New(data []byte, opts ...Option) (*Session, error) {
+	return NewWithReader(bytes.NewReader(data), opts...)
 }
 
 // NewWithReader new session with reader
-func NewWithReader(r io.Reader) (*Session, error) {
+func NewWithReader(r io.Reader, opts ...Option) (*Session, error) {
 	v := viper.New()
 	v.SetConfigType("yaml")
 	if err := v.ReadConfig(r); err != nil {
 		return nil, err
 	}
 
-	return NewWithViper(v), nil
+	return Open(v, opts...)
 }
 
-// NewWithViper new session with viper
+// NewWithViper new session with viper, panics if any backend fails to open
 func NewWithViper(v *viper.Viper) *Session {
-	s := &Session{v: v}
-	s.memory = cache.New(time.Hour, time.Minute*10)
-	s.redis = openRedis(v)
-	s.mysqlRead, s.mysqlWrite = openMysql(v)
-	s.aws = awsSession(v)
+	s, err := Open(v)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	return s
 }
 
+// Open open every configured backend, failures are reported together as OpenError.
+// key_file secrets, WithEnv overrides and WithInterpolation are applied to a
+// copy of v, the session keeps that copy and v is left untouched
+func Open(v *viper.Viper, opts ...Option) (*Session, error) {
+	o := newOptions(opts)
+	v, err := applyEnv(v, o)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateConfig(v); err != nil {
+		return nil, err
+	}
+
+	s := &Session{opts: o, backends: &backends{v: v}}
+	s.memory, s.store = openMemory(v, o)
+
+	var errs OpenError
+	b := s.backends
+	b.redises, b.defaultRedis = openRedises(v, o, &errs)
+	b.dbs, b.defaultDB = openDBs(v, o, &errs)
+	b.aws = awsSession(v)
+	if cfg, err := openAWS(v); err != nil {
+		errs = append(errs, &BackendError{Backend: "aws", Err: err})
+	} else {
+		b.awsCfg = cfg
+	}
+
+	if st, err := openStorage(v, b.awsCfg); err != nil {
+		errs = append(errs, &BackendError{Backend: "storage", Err: err})
+	} else {
+		b.storage = st
+	}
+	b.watch = newWatchState(v)
+
+	if len(errs) > 0 {
+		s.Close()
+		return nil, errs
+	}
+
+	return s, nil
+}
+
 // Copy copy
 func (s *Session) Copy() *Session {
 	return &Session{
-		memory:     s.memory,
-		redis:      s.redis,
-		mysqlRead:  s.mysqlRead,
-		mysqlWrite: s.mysqlWrite,
-		aws:        s.aws,
-		v:          s.v,
-		ctx:        s.ctx,
+		memory:      s.memory,
+		store:       s.store,
+		dbName:      s.dbName,
+		dbWrite:     s.dbWrite,
+		tx:          s.tx,
+		readOnWrite: s.readOnWrite,
+		backends:    s.backends,
+		prefix:      s.prefix,
+		opts:        s.opts,
+		ctx:         s.ctx,
 	}
 }
 
 // Close close
 func (s *Session) Close() {
-	if c := s.redis; c != nil {
-		c.Close()
+	if b := s.backends; b != nil {
+		b.close()
 	}
 
-	if c := s.mysqlRead; c != nil {
+	if c, ok := s.store.(io.Closer); ok {
 		c.Close()
 	}
+}
 
-	if c := s.mysqlWrite; c != nil {
-		c.Close()
+// DB session on the named db instance, its DB*/Mysql* accessors use the instance.
+// If the instance isn't configured their statements fail with ErrNoDB
+func (s *Session) DB(name string) *Session {
+	cp := s.Copy()
+	cp.dbName, cp.dbWrite, cp.tx, cp.readOnWrite = name, nil, nil, false
+	return cp
+}
+
+// instance db instance selected by DB, or the default one
+func (s *Session) instance() *dbInstance {
+	if s.backends == nil {
+		return nil
 	}
+
+	return s.backends.db(s.dbName)
 }
 
-// Redis redis
-func (s *Session) Redis() *redis.Client {
-	return s.redis
+// NamedRedis redis of the named instance, nil if it isn't configured
+func (s *Session) NamedRedis(name string) redis.UniversalClient {
+	if b := s.backends; b != nil && name != "" {
+		return b.redis(name)
+	}
+
+	return nil
+}
+
+// Redis redis of the default instance, pass the session as the command
+// context to trace it, eg s.Redis().Get(s, key)
+func (s *Session) Redis() redis.UniversalClient {
+	if b := s.backends; b != nil {
+		return b.redis("")
+	}
+
+	return nil
 }
 
-// AWSSession aws session
+// AWSSession aws sdk v1 session with the static key & secret of the aws section.
+//
+// Deprecated: use AWSConfig, which supports the default credential chain
 func (s *Session) AWSSession() *session.Session {
-	return s.aws
+	if b := s.backends; b != nil {
+		return b.awsSession()
+	}
+
+	return nil
 }
 
-// Viper viper
+// Viper viper of the current config, or of the key set by SubViper, nil if
+// the key is missing. A reload replaces it, keep the session rather than the viper
 func (s *Session) Viper() *viper.Viper {
-	return s.v
+	if s.backends == nil {
+		return nil
+	}
+
+	v := s.backends.viper()
+	if s.prefix != "" && v != nil {
+		v = v.Sub(s.prefix)
+	}
+
+	return v
 }
 
 // SubViper sub viper
 func (s *Session) SubViper(key string) *Session {
 	cp := s.Copy()
-	cp.v = s.v.Sub(key)
+	cp.prefix = configKey(s.prefix, key)
 	return cp
 }
 
-// UnmarshalViperWithTag unmarshal with tag
-func (s *Session) UnmarshalViperWithTag(r interface{}, tag string) error {
-	return s.Viper().Unmarshal(r, func(opt *mapstructure.DecoderConfig) {
-		opt.TagName = tag
-	})
+// MemoryCache memory cache, unbounded, with the default_ttl & cleanup_interval
+// of the memory section. It isn't the store of Memory.
+//
+// Deprecated: use Memory, which is bounded by max_entries & max_bytes
+func (s *Session) MemoryCache() *cache.Cache {
+	return s.memory
 }
 
-// UnmarshalViper unmarshal
-func (s *Session) UnmarshalViper(r interface{}) error {
-	return s.UnmarshalViperWithTag(r, "json")
+// Memory memory store sized by the memory section, or set by WithMemoryStore
+func (s *Session) Memory() MemoryStore {
+	return s.store
 }
 
-// MemoryCache memory cache
-func (s *Session) MemoryCache() *cache.Cache {
-	return s.memory
+// MemoryStats stats of Memory
+func (s *Session) MemoryStats() MemoryStats {
+	return s.store.Stats()
+}
+
+// withContext carry the session context into gorm statements
+func (s *Session) withContext(db *gorm.DB) *gorm.DB {
+	if s.ctx == nil {
+		return db
+	}
+
+	return db.WithContext(s.ctx)
+}
+
+// readDB db read without the session context
+func (s *Session) readDB() *gorm.DB {
+	if s.readOnWrite || s.dbWrite != nil {
+		return s.writeDB()
+	}
+
+	if inst := s.instance(); inst != nil {
+		return inst.read
+	}
+
+	return noDB()
+}
+
+// writeDB db write without the session context
+func (s *Session) writeDB() *gorm.DB {
+	if s.dbWrite != nil {
+		return s.dbWrite
+	}
+
+	if inst := s.instance(); inst != nil {
+		return inst.write
+	}
+
+	return noDB()
+}
+
+// DBRead db read of the default instance, or the one selected by DB
+func (s *Session) DBRead() *gorm.DB {
+	return s.withContext(s.readDB())
+}
+
+// DBWrite db write of the default instance, or the one selected by DB
+func (s *Session) DBWrite() *gorm.DB {
+	return s.withContext(s.writeDB())
+}
+
+// DBReadFresh db read on a replica lagging at most maxLag, or on the writer
+// if there is none, or in a transaction or DBReadOnWrite
+func (s *Session) DBReadFresh(maxLag time.Duration) *gorm.DB {
+	inst := s.instance()
+	if inst == nil || inst.replicas == nil || s.readOnWrite || s.dbWrite != nil || maxLag <= 0 {
+		return s.DBWrite()
+	}
+
+	if r := inst.replicas.pick(inst.replicas.available(maxLag)); r != nil {
+		return s.withContext(r.db)
+	}
+
+	return s.DBWrite()
+}
+
+// DBReadOnWrite db all write
+func (s *Session) DBReadOnWrite() *Session {
+	s = s.Copy()
+	s.readOnWrite = true
+	return s
+}
+
+// DBBegin db begin
+func (s *Session) DBBegin() *Session {
+	cp := s.Copy()
+	cp.dbWrite = s.DBWrite().Begin()
+	cp.tx = &txState{}
+	return cp
+}
+
+// DBRollback db rollback
+func (s *Session) DBRollback() *gorm.DB {
+	if s.tx != nil {
+		s.tx.hooks = nil
+	}
+
+	return s.writeDB().Rollback()
+}
+
+// DBRollbackUnlessCommitted rollback unless committed
+func (s *Session) DBRollbackUnlessCommitted() *gorm.DB {
+	db := s.writeDB()
+	if tx, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok && tx != nil {
+		err := tx.Rollback()
+		// Ignore the error indicating that the transaction has already
+		// been committed.
+		if err != nil && err != sql.ErrTxDone {
+			db.AddError(err)
+		}
+	} else {
+		db.AddError(gorm.ErrInvalidTransaction)
+	}
+
+	return db
+}
+
+// DBCommit db commit, then run the AfterCommit hooks
+func (s *Session) DBCommit() *gorm.DB {
+	db := s.writeDB().Commit()
+	if db.Error == nil && s.tx != nil && s.tx.parent == nil {
+		s.tx.commit()
+	}
+
+	return db
 }
 
+// mysql, kept as aliases of the db accessors
+
 // MysqlRead mysql read
 func (s *Session) MysqlRead() *gorm.DB {
-	return s.mysqlRead
+	return s.DBRead()
 }
 
 // MysqlWrite mysql write
 func (s *Session) MysqlWrite() *gorm.DB {
-	return s.mysqlWrite
+	return s.DBWrite()
 }
 
 // MysqlReadOnWrite mysql all write
 func (s *Session) MysqlReadOnWrite() *Session {
-	s = s.Copy()
-	s.mysqlRead = s.mysqlWrite
-	return s
+	return s.DBReadOnWrite()
 }
 
 // MysqlBegin mysql begin
 func (s *Session) MysqlBegin() *Session {
-	cp := s.Copy()
-	cp.mysqlWrite = s.MysqlWrite().Begin()
-	return cp
+	return s.DBBegin()
 }
 
 // MysqlRollback mysql rollback
 func (s *Session) MysqlRollback() *gorm.DB {
-	return s.mysqlWrite.Rollback()
+	return s.DBRollback()
 }
 
 // MysqlRollbackUnlessCommitted rollback unless committed
 func (s *Session) MysqlRollbackUnlessCommitted() *gorm.DB {
-	type sqlTx interface {
-		Rollback() error
-	}
-
-	var emptySQLTx *sql.Tx
-	if db, ok := s.mysqlWrite.CommonDB().(sqlTx); ok && db != nil && db != emptySQLTx {
-		err := db.Rollback()
-		// Ignore the error indicating that the transaction has already
-		// been committed.
-		if err != sql.ErrTxDone {
-			s.mysqlWrite.AddError(err)
-		}
-	} else {
-		s.mysqlWrite.AddError(gorm.ErrInvalidTransaction)
-	}
-
-	return s.mysqlWrite
+	return s.DBRollbackUnlessCommitted()
 }
 
 // MysqlCommit mysql commit
 func (s *Session) MysqlCommit() *gorm.DB {
-	return s.mysqlWrite.Commit()
+	return s.DBCommit()
 }
 
 // context.Context
@@ -215,7 +406,7 @@ func (s *Session) Context() context.Context {
 	return context.Background()
 }
 
-// WithContext with context
+// WithContext with context, the active span of ctx parents the db & redis spans
 func (s *Session) WithContext(ctx context.Context) *Session {
 	if ctx == nil {
 		panic("nil context")
@@ -232,7 +423,7 @@ func IsErrNotFound(err error) bool {
 	case redis.Nil, ErrNotFound:
 		return true
 	default:
-		return gorm.IsRecordNotFoundError(err)
+		return errors.Is(err, gorm.ErrRecordNotFound)
 	}
 }
 
@@ -0,0 +1,439 @@
+package session
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+type testUser struct {
+	ID   int64  `gorm:"primaryKey"`
+	Name string `gorm:"size:64"`
+}
+
+func newSqliteSession(t *testing.T) *Session {
+	s, err := New([]byte(`
+sqlite:
+  db: ":memory:"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSqliteSession(t *testing.T) {
+	s := newSqliteSession(t)
+
+	h := s.Health(context.Background())
+	assert.True(t, h.Healthy)
+	if assert.Len(t, h.Backends, 1) {
+		assert.Equal(t, "db", h.Backends[0].Name)
+	}
+
+	assert.Nil(t, SetdbFuncs(AutoMigrate(&testUser{}), AddIndex(&testUser{}, "idx_name", "name"))(s.DBWrite()))
+	assert.True(t, s.DBWrite().Migrator().HasIndex(&testUser{}, "idx_name"))
+
+	assert.Nil(t, s.MysqlWrite().Create(&testUser{ID: 1, Name: "foo"}).Error)
+
+	var user testUser
+	assert.Nil(t, s.DBRead().First(&user, 1).Error)
+	assert.Equal(t, "foo", user.Name)
+
+	err := s.DBRead().First(&testUser{}, 2).Error
+	assert.True(t, s.IsErrNotFound(err))
+
+	tx := s.DBBegin()
+	assert.Nil(t, tx.DBWrite().Create(&testUser{ID: 2, Name: "bar"}).Error)
+	assert.Nil(t, tx.DBRollback().Error)
+	assert.True(t, IsErrNotFound(s.DBRead().First(&testUser{}, 2).Error))
+
+	tx = s.DBBegin()
+	assert.Nil(t, tx.DBWrite().Create(&testUser{ID: 2, Name: "bar"}).Error)
+	assert.Nil(t, tx.DBCommit().Error)
+	assert.Nil(t, tx.DBRollbackUnlessCommitted().Error)
+	assert.Nil(t, s.DBRead().First(&testUser{}, 2).Error)
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, data := range []string{
+		"mysql:\n  read: localhost\n  conn_max_lifetime: 1 hour\n",
+		"mysql:\n  read: localhost\n  max_open_conns: 5\n  max_idle_conns: 10\n",
+		"mysql:\n  read: localhost\n  loc: Mars/Olympus\n",
+		"postgres:\n  read: localhost\n  tls: maybe\n",
+		"redis:\n  addr: localhost:6379\n  pool_size: -1\n",
+		"redis:\n  db: 1\n",
+	} {
+		_, err := New([]byte(data))
+		var cfgErr *ConfigError
+		assert.True(t, errors.As(err, &cfgErr), data)
+	}
+}
+
+func TestOpenError(t *testing.T) {
+	data := []byte(`
+mysql:
+  read: 127.0.0.1:1
+  timeout: 1s
+redis:
+  addr: 127.0.0.1:1
+  dial_timeout: 1s
+`)
+
+	_, err := New(data, WithRetry(2, time.Millisecond))
+	var openErr OpenError
+	if assert.True(t, errors.As(err, &openErr)) {
+		assert.Len(t, openErr, 2)
+	}
+
+	s, err := New(data, WithLazyConnect())
+	if assert.Nil(t, err) {
+		s.Close()
+	}
+}
+
+func TestNamedInstances(t *testing.T) {
+	s, err := New([]byte(`
+sqlite:
+  main:
+    db: ":memory:"
+  analytics:
+    db: ":memory:"
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	analytics := s.DB("analytics")
+	assert.Nil(t, analytics.DBWrite().AutoMigrate(&testUser{}))
+	assert.True(t, analytics.DBRead().Migrator().HasTable(&testUser{}))
+	assert.False(t, s.DBRead().Migrator().HasTable(&testUser{}))
+	unknown := s.DB("unknown")
+	assert.Equal(t, ErrNoDB, unknown.DBRead().First(&testUser{}).Error)
+	assert.Equal(t, ErrNoDB, unknown.DBWrite().Create(&testUser{ID: 1}).Error)
+	assert.Equal(t, ErrNoDB, unknown.Transaction(func(tx *Session) error { return nil }))
+
+	h := s.Health(context.Background())
+	if assert.Len(t, h.Backends, 2) {
+		assert.Equal(t, "db", h.Backends[0].Name)
+		assert.Equal(t, "db.analytics", h.Backends[1].Name)
+	}
+
+	_, err = New([]byte(`
+sqlite:
+  a:
+    db: ":memory:"
+  b:
+    db: ":memory:"
+`))
+	var cfgErr *ConfigError
+	assert.True(t, errors.As(err, &cfgErr))
+}
+
+func TestReplicas(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New([]byte(`
+sqlite:
+  write: ` + dir + `/write.db
+  read:
+  - ` + dir + `/read_0.db
+  - ` + dir + `/read_1.db
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+	// checks are run by hand
+	replicas := s.instance().replicas
+	replicas.close()
+
+	var names []string
+	for _, b := range s.Health(context.Background()).Backends {
+		names = append(names, b.Name)
+	}
+	assert.Equal(t, []string{"db_read_0", "db_read_1", "db_write"}, names)
+
+	// every replica gets its own table, reads tell which one served them
+	for idx, r := range replicas.replicas {
+		assert.Nil(t, r.db.Exec("CREATE TABLE src (name TEXT)").Error)
+		assert.Nil(t, r.db.Exec("INSERT INTO src VALUES (?)", r.name).Error)
+		assert.Equal(t, fmt.Sprintf("db_read_%d", idx), r.name)
+	}
+	assert.Nil(t, s.DBWrite().Exec("CREATE TABLE src (name TEXT)").Error)
+	assert.Nil(t, s.DBWrite().Exec("INSERT INTO src VALUES ('db_write')").Error)
+
+	source := func(db *gorm.DB) (name string) {
+		db.Raw("SELECT name FROM src").Row().Scan(&name)
+		return
+	}
+
+	assert.ElementsMatch(t, []string{"db_read_0", "db_read_1"}, []string{source(s.DBRead()), source(s.DBRead())})
+	assert.Equal(t, "db_write", source(s.DBReadOnWrite().DBRead()))
+
+	// sqlite replicas never lag
+	replicas.check(context.Background())
+	assert.Contains(t, []string{"db_read_0", "db_read_1"}, source(s.DBReadFresh(time.Second)))
+	assert.Equal(t, "db_write", source(s.DBReadFresh(0)))
+
+	// a failed lag query takes the replica out of lag bound reads & health
+	replicas.maxLag = time.Second
+	replicas.dialect.lag = func(context.Context, *sql.DB) (time.Duration, error) {
+		return 0, errors.New("no privilege")
+	}
+	replicas.check(context.Background())
+	assert.Equal(t, "db_write", source(s.DBReadFresh(time.Second)))
+	h := s.Health(context.Background())
+	assert.False(t, h.Healthy)
+	for _, b := range h.Backends {
+		if b.Name != "db_write" {
+			assert.Equal(t, "replication lag unknown: no privilege", b.Error)
+		}
+	}
+	replicas.maxLag = 0
+
+	for _, r := range replicas.replicas {
+		r.mu.Lock()
+		r.down = true
+		r.mu.Unlock()
+	}
+	assert.Equal(t, "db_write", source(s.DBRead()))
+	assert.Equal(t, "db_write", source(s.DBReadFresh(time.Second)))
+}
+
+func TestReplicaOpenError(t *testing.T) {
+	dir := t.TempDir()
+	_, err := New([]byte(`
+sqlite:
+  write: ` + dir + `/write.db
+  read:
+  - ` + dir + `/read.db
+  - ` + dir + `/missing/read.db
+`))
+	var backendErr *BackendError
+	if assert.True(t, errors.As(err, &backendErr)) {
+		assert.Equal(t, "db", backendErr.Backend)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	s := newSqliteSession(t)
+	assert.Nil(t, s.DBWrite().AutoMigrate(&testUser{}))
+
+	var committed []string
+	err := s.Transaction(func(tx *Session) error {
+		tx.AfterCommit(func() { committed = append(committed, "outer") })
+		if err := tx.DBWrite().Create(&testUser{ID: 1, Name: "foo"}).Error; err != nil {
+			return err
+		}
+
+		// the failed savepoint is rolled back alone, its hooks are dropped
+		err := tx.Transaction(func(tx *Session) error {
+			tx.AfterCommit(func() { committed = append(committed, "failed") })
+			tx.DBWrite().Create(&testUser{ID: 2, Name: "bar"})
+			return errors.New("nested")
+		})
+		assert.EqualError(t, err, "nested")
+
+		return tx.Transaction(func(tx *Session) error {
+			tx.AfterCommit(func() { committed = append(committed, "nested") })
+			assert.Empty(t, committed)
+			return tx.DBWrite().Create(&testUser{ID: 3, Name: "baz"}).Error
+		})
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"outer", "nested"}, committed)
+
+	var count int64
+	s.DBRead().Model(&testUser{}).Count(&count)
+	assert.EqualValues(t, 2, count)
+	assert.True(t, IsErrNotFound(s.DBRead().First(&testUser{}, 2).Error))
+
+	err = s.Transaction(func(tx *Session) error {
+		tx.DBWrite().Create(&testUser{ID: 4})
+		return errors.New("rollback")
+	})
+	assert.EqualError(t, err, "rollback")
+	assert.True(t, IsErrNotFound(s.DBRead().First(&testUser{}, 4).Error))
+
+	assert.Panics(t, func() {
+		s.Transaction(func(tx *Session) error {
+			tx.DBWrite().Create(&testUser{ID: 5})
+			panic("boom")
+		})
+	})
+	assert.True(t, IsErrNotFound(s.DBRead().First(&testUser{}, 5).Error))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	err = s.WithContext(ctx).Transaction(func(tx *Session) error {
+		return nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
+
+func TestTransactionRetry(t *testing.T) {
+	s := newSqliteSession(t)
+	before := TxRetryStats()
+
+	attempts := 0
+	err := s.Transaction(func(tx *Session) error {
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("insert: %w", &driver.MySQLError{Number: 1213, Message: "Deadlock found"})
+		}
+
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+
+	attempts = 0
+	err = s.Transaction(func(tx *Session) error {
+		attempts++
+		return &pgconn.PgError{Code: "55P03"}
+	})
+	assert.True(t, IsTimeout(err))
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = s.Transaction(func(tx *Session) error {
+		attempts++
+		return &driver.MySQLError{Number: 1062}
+	})
+	assert.True(t, s.IsDuplicateKey(err))
+	assert.False(t, s.IsDeadlock(err))
+	assert.Equal(t, 1, attempts)
+
+	after := TxRetryStats()
+	assert.EqualValues(t, 3, after.Retries-before.Retries)
+	assert.EqualValues(t, 1, after.Deadlocks-before.Deadlocks)
+	assert.EqualValues(t, 2, after.LockTimeouts-before.LockTimeouts)
+	assert.EqualValues(t, 1, after.Exhausted-before.Exhausted)
+
+	assert.Nil(t, s.DBWrite().AutoMigrate(&testUser{}))
+	assert.Nil(t, s.DBWrite().Create(&testUser{ID: 1}).Error)
+	assert.True(t, IsDuplicateKey(s.DBWrite().Create(&testUser{ID: 1}).Error))
+}
+
+func TestMigrations(t *testing.T) {
+	defer func(saved map[int64]*Migration) { migrations = saved }(migrations)
+	migrations = map[int64]*Migration{}
+
+	RegisterMigration(1, "create_users", AutoMigrate(&testUser{}), func(db *gorm.DB) error {
+		return db.Migrator().DropTable(&testUser{})
+	})
+	assert.Panics(t, func() { RegisterMigration(1, "again", AutoMigrate(&testUser{}), nil) })
+
+	assert.Nil(t, RegisterMigrationFS(fstest.MapFS{
+		"2_add_index.up.sql": &fstest.MapFile{Data: []byte(`
+-- index on name
+CREATE INDEX idx_users_name
+  ON test_users (name);
+INSERT INTO test_users (id, name) VALUES (1, 'a;b');
+`)},
+		"2_add_index.down.sql":  &fstest.MapFile{Data: []byte("DROP INDEX idx_users_name;\nDELETE FROM test_users;\n")},
+		"3_irreversible.up.sql": &fstest.MapFile{Data: []byte("SELECT 1;")},
+		"README.md":             &fstest.MapFile{},
+	}))
+	assert.NotNil(t, RegisterMigrationFS(fstest.MapFS{"4_no_up.down.sql": &fstest.MapFile{}}))
+
+	s := newSqliteSession(t)
+	m := NewMigrator(s)
+
+	// status only reads
+	status, err := m.Status()
+	assert.Nil(t, err)
+	assert.Len(t, status, 3)
+	assert.False(t, s.DBWrite().Migrator().HasTable(MigrationTable))
+
+	assert.Nil(t, Setdb(s))
+	status, err = m.Status()
+	assert.Nil(t, err)
+	if assert.Len(t, status, 3) {
+		assert.Equal(t, "add_index", status[1].Name)
+		assert.True(t, status[2].Applied)
+	}
+	assert.True(t, s.DBWrite().Migrator().HasIndex(&testUser{}, "idx_users_name"))
+
+	var user testUser
+	assert.Nil(t, s.DBRead().First(&user, 1).Error)
+	assert.Equal(t, "a;b", user.Name)
+
+	// running again is a no-op
+	assert.Nil(t, m.Up())
+
+	assert.True(t, errors.Is(m.Down(), ErrIrreversible))
+	delete(migrations, 3)
+	m = NewMigrator(s)
+
+	// applied but no longer registered, it isn't skipped
+	assert.True(t, errors.Is(m.Down(), ErrUnregistered))
+	assert.True(t, errors.Is(m.Redo(), ErrUnregistered))
+	status, _ = m.Status()
+	if assert.Len(t, status, 3) {
+		assert.Equal(t, int64(3), status[2].Version)
+		assert.True(t, status[2].Applied)
+	}
+
+	assert.Nil(t, s.DBWrite().Table(MigrationTable).Delete(&schemaMigration{}, 3).Error)
+	assert.Nil(t, m.Redo())
+	assert.Nil(t, m.Down())
+	assert.False(t, s.DBWrite().Migrator().HasIndex(&testUser{}, "idx_users_name"))
+
+	status, _ = m.Status()
+	if assert.Len(t, status, 2) {
+		assert.True(t, status[0].Applied)
+		assert.False(t, status[1].Applied)
+	}
+
+	assert.Nil(t, m.Up())
+	assert.True(t, s.DBWrite().Migrator().HasIndex(&testUser{}, "idx_users_name"))
+}
+
+func TestMigrationsPinned(t *testing.T) {
+	defer func(saved map[int64]*Migration) { migrations = saved }(migrations)
+	migrations = map[int64]*Migration{}
+	RegisterMigration(1, "create_users", AutoMigrate(&testUser{}), nil)
+
+	s, err := New([]byte(`
+sqlite:
+  db: ` + t.TempDir() + `/db.sqlite
+  max_open_conns: 1
+  max_idle_conns: 1
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	// the lock holds the only connection, migrations must run on it
+	var locked bool
+	inst := s.instance()
+	inst.dialect.lock = func(context.Context, *sql.Conn, string) error {
+		locked = true
+		return nil
+	}
+	inst.dialect.unlock = func(context.Context, *sql.Conn, string) error {
+		locked = false
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.Nil(t, Setdb(s.WithContext(ctx)))
+	assert.False(t, locked)
+	assert.True(t, s.DBWrite().Migrator().HasTable(&testUser{}))
+}
@@ -0,0 +1,254 @@
+package session
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/viper"
+)
+
+const (
+	StorageS3 = "s3"
+	StorageFS = "fs"
+)
+
+// ObjectInfo stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// Storage object storage behind Session.Storage, missing objects are ErrNotFound
+type Storage interface {
+	// Put store r at key, large bodies are uploaded in parts
+	Put(ctx context.Context, key string, r io.Reader, opts ...PutOption) (*ObjectInfo, error)
+	// Get the content of key, to be closed by the caller
+	Get(ctx context.Context, key string) (io.ReadCloser, *ObjectInfo, error)
+	// Delete delete key, deleting a missing key isn't an error
+	Delete(ctx context.Context, key string) error
+	// List every object whose key starts with prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]*ObjectInfo, error)
+	// PresignGet url downloading key without credentials until ttl
+	PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error)
+	// PresignPut url uploading key with an http PUT until ttl, the upload
+	// must be sent with contentType if it isn't empty
+	PresignPut(ctx context.Context, key string, ttl time.Duration, contentType string) (string, error)
+}
+
+type putOptions struct {
+	contentType string
+}
+
+// PutOption option of Storage.Put
+type PutOption func(*putOptions)
+
+// WithContentType content type of the object, detected from the key
+// extension or the content otherwise
+func WithContentType(contentType string) PutOption {
+	return func(o *putOptions) {
+		o.contentType = contentType
+	}
+}
+
+func newPutOptions(opts []PutOption) *putOptions {
+	o := &putOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+type storageOptions struct {
+	prefix      string
+	secret      string
+	pathStyle   bool
+	partSize    int64
+	concurrency int
+}
+
+// StorageOption option of NewS3Storage & NewFSStorage
+type StorageOption func(*storageOptions)
+
+// WithStoragePrefix prepend prefix to every key
+func WithStoragePrefix(prefix string) StorageOption {
+	return func(o *storageOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithPathStyle address s3 buckets by path, eg for MinIO
+func WithPathStyle(pathStyle bool) StorageOption {
+	return func(o *storageOptions) {
+		o.pathStyle = pathStyle
+	}
+}
+
+// WithPartSize upload s3 bodies larger than partSize in parts, concurrency
+// parts at a time. 0 keeps the defaults of 5MB & 5
+func WithPartSize(partSize int64, concurrency int) StorageOption {
+	return func(o *storageOptions) {
+		o.partSize = partSize
+		o.concurrency = concurrency
+	}
+}
+
+// WithStorageSecret key signing the presigned urls of the fs storage,
+// random by default so urls don't survive a restart
+func WithStorageSecret(secret string) StorageOption {
+	return func(o *storageOptions) {
+		o.secret = secret
+	}
+}
+
+func newStorageOptions(opts []StorageOption) *storageOptions {
+	o := &storageOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// sniffLen bytes read by http.DetectContentType
+const sniffLen = 512
+
+// detectContentType content type of key by its extension, or by the first
+// bytes of r. The returned reader replays them
+func detectContentType(key string, r io.Reader) (string, io.Reader, error) {
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t, r, nil
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+
+	head = head[:n]
+	return http.DetectContentType(head), io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+// errBadKey key escaping the storage
+var errBadKey = errors.New("session: bad storage key")
+
+// cleanKey key relative to the storage root, errBadKey if it is empty
+func cleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	if key == "" {
+		return "", errBadKey
+	}
+
+	return key, nil
+}
+
+// checkPrefix list prefix, errBadKey if it could leave the storage prefix.
+// It isn't cleaned, a prefix may end in the middle of a name
+func checkPrefix(prefix string) error {
+	if strings.HasPrefix(prefix, "/") {
+		return errBadKey
+	}
+
+	for _, seg := range strings.Split(prefix, "/") {
+		if seg == "." || seg == ".." {
+			return errBadKey
+		}
+	}
+
+	return nil
+}
+
+// storageConfig section of storage
+type storageConfig struct {
+	Driver string
+	// Prefix prepended to every key
+	Prefix string
+
+	// s3
+	Bucket      string
+	PathStyle   bool
+	PartSize    int
+	Concurrency int
+
+	// fs
+	Dir     string
+	BaseURL string
+	Secret  string
+}
+
+// parseStorageConfig nil if the storage section is missing
+func parseStorageConfig(v *viper.Viper) (*storageConfig, error) {
+	if v = v.Sub("storage"); v == nil {
+		return nil, nil
+	}
+
+	r := &configReader{v: v, prefix: "storage"}
+	cfg := &storageConfig{
+		Driver:      r.OneOf("driver", "", StorageS3, StorageFS),
+		Prefix:      r.String("prefix"),
+		Bucket:      r.String("bucket"),
+		PathStyle:   r.Bool("path_style"),
+		PartSize:    r.Int("part_size", 0),
+		Concurrency: r.Int("concurrency", 0),
+		Dir:         r.String("dir"),
+		BaseURL:     r.String("base_url"),
+		Secret:      r.String("secret"),
+	}
+
+	switch cfg.Driver {
+	case "", StorageS3:
+		cfg.Driver = StorageS3
+		if cfg.Bucket == "" {
+			r.fail("bucket", errors.New("is required"))
+		}
+	case StorageFS:
+		if cfg.Dir == "" {
+			r.fail("dir", errors.New("is required"))
+		}
+	}
+
+	return cfg, r.err
+}
+
+// openStorage storage of the storage section, s3 uses the aws section
+func openStorage(v *viper.Viper, awsCfg *aws.Config) (Storage, error) {
+	cfg, err := parseStorageConfig(v)
+	if err != nil || cfg == nil {
+		return nil, err
+	}
+
+	if cfg.Driver == StorageFS {
+		return NewFSStorage(cfg.Dir, cfg.BaseURL, WithStoragePrefix(cfg.Prefix), WithStorageSecret(cfg.Secret))
+	}
+
+	if awsCfg == nil {
+		return nil, errors.New("the aws section is required by the s3 storage")
+	}
+
+	return NewS3Storage(*awsCfg, cfg.Bucket,
+		WithStoragePrefix(cfg.Prefix),
+		WithPathStyle(cfg.PathStyle),
+		WithPartSize(int64(cfg.PartSize), cfg.Concurrency),
+	), nil
+}
+
+// Storage object storage of the storage section, nil if it is missing
+func (s *Session) Storage() Storage {
+	if b := s.backends; b != nil {
+		return b.objectStorage()
+	}
+
+	return nil
+}
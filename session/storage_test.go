@@ -0,0 +1,263 @@
+package session
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFSStorage(t *testing.T) {
+	ctx := context.Background()
+	srv := httptest.NewUnstartedServer(nil)
+	s, err := New([]byte(`
+storage:
+  driver: fs
+  dir: ` + t.TempDir() + `
+  prefix: uploads/
+  base_url: http://` + srv.Listener.Addr().String() + `/files
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	st := s.Storage()
+	srv.Config.Handler = st.(*FSStorage)
+	srv.Start()
+	defer srv.Close()
+
+	info, err := st.Put(ctx, "a/readme.txt", strings.NewReader("hello"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "a/readme.txt", info.Key)
+		assert.Equal(t, int64(5), info.Size)
+		assert.Equal(t, "text/plain; charset=utf-8", info.ContentType)
+		assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", info.ETag)
+	}
+
+	// without an extension the content tells the type
+	info, err = st.Put(ctx, "a/logo", strings.NewReader("\x89PNG\r\n\x1a\n0000"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "image/png", info.ContentType)
+	}
+
+	body, info, err := st.Get(ctx, "a/readme.txt")
+	if assert.Nil(t, err) {
+		data, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, "hello", string(data))
+		assert.Equal(t, "text/plain; charset=utf-8", info.ContentType)
+	}
+
+	_, _, err = st.Get(ctx, "missing")
+	assert.True(t, IsErrNotFound(err))
+
+	objects, err := st.List(ctx, "a/")
+	if assert.Nil(t, err) && assert.Len(t, objects, 2) {
+		assert.Equal(t, "a/logo", objects[0].Key)
+		assert.Equal(t, "a/readme.txt", objects[1].Key)
+	}
+
+	// sorted by key, not in walk order
+	_, err = st.Put(ctx, "a.txt", strings.NewReader("a"))
+	assert.Nil(t, err)
+	objects, err = st.List(ctx, "a")
+	if assert.Nil(t, err) && assert.Len(t, objects, 3) {
+		assert.Equal(t, "a.txt", objects[0].Key)
+		assert.Equal(t, "a/logo", objects[1].Key)
+	}
+
+	objects, err = st.List(ctx, "missing/")
+	assert.Nil(t, err)
+	assert.Empty(t, objects)
+
+	for _, prefix := range []string{"../", "a/../../", "/etc", "./a"} {
+		_, err = st.List(ctx, prefix)
+		assert.Equal(t, errBadKey, err, prefix)
+	}
+
+	// presigned urls are served by the storage
+	putURL, err := st.PresignPut(ctx, "b/data.json", time.Minute, "application/json")
+	if assert.Nil(t, err) {
+		req, _ := http.NewRequest(http.MethodPut, putURL, strings.NewReader(`{"a":1}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if assert.Nil(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+
+		req, _ = http.NewRequest(http.MethodPut, putURL, strings.NewReader(`{"a":1}`))
+		req.Header.Set("Content-Type", "text/plain")
+		resp, err = http.DefaultClient.Do(req)
+		if assert.Nil(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		}
+	}
+
+	getURL, err := st.PresignGet(ctx, "b/data.json", time.Minute)
+	if assert.Nil(t, err) {
+		resp, err := http.Get(getURL)
+		if assert.Nil(t, err) {
+			data, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, `{"a":1}`, string(data))
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		}
+
+		resp, err = http.Get(strings.Replace(getURL, "data.json", "other.json", 1))
+		if assert.Nil(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		}
+	}
+
+	assert.Nil(t, st.Delete(ctx, "a/readme.txt"))
+	assert.Nil(t, st.Delete(ctx, "a/readme.txt"))
+	_, _, err = st.Get(ctx, "a/readme.txt")
+	assert.Equal(t, ErrNotFound, err)
+
+	// keys stay under the prefix
+	_, err = st.Put(ctx, "../../etc/passwd", strings.NewReader("x"))
+	assert.Nil(t, err)
+	objects, _ = st.List(ctx, "etc/")
+	assert.Len(t, objects, 1)
+
+	_, err = New([]byte("storage:\n  driver: s3\n"))
+	assert.EqualError(t, err, "session: invalid config storage.bucket: is required")
+}
+
+// fakeS3 s3 endpoint serving path style requests on a single bucket
+func fakeS3(t *testing.T, objects map[string]string) (*httptest.Server, *[]string) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+			prefix := r.URL.Query().Get("prefix")
+			w.Header().Set("Content-Type", "application/xml")
+			io.WriteString(w, `<ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+			for k, v := range objects {
+				if strings.HasPrefix(k, prefix) {
+					io.WriteString(w, `<Contents><Key>`+k+`</Key><Size>`+strconv.Itoa(len(v))+`</Size><ETag>"etag"</ETag><LastModified>2024-01-01T00:00:00.000Z</LastModified></Contents>`)
+				}
+			}
+			io.WriteString(w, `</ListBucketResult>`)
+		case r.Method == http.MethodGet:
+			v, ok := objects[key]
+			if !ok {
+				w.Header().Set("Content-Type", "application/xml")
+				w.WriteHeader(http.StatusNotFound)
+				io.WriteString(w, `<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"etag"`)
+			io.WriteString(w, v)
+		case r.Method == http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			objects[key] = string(data)
+			w.Header().Set("ETag", `"etag"`)
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, &requests
+}
+
+func TestS3Storage(t *testing.T) {
+	ctx := context.Background()
+	objects := map[string]string{"p/docs/b.txt": "b", "p/docs/a.txt": "a", "other/c.txt": "c"}
+	srv, requests := fakeS3(t, objects)
+
+	s, err := New([]byte(`
+aws:
+  region: us-east-1
+  key: AKID
+  secret: SECRET
+  endpoint: ` + srv.URL + `
+storage:
+  bucket: bucket
+  prefix: p/
+  path_style: true
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	st := s.Storage()
+	if !assert.IsType(t, &S3Storage{}, st) {
+		return
+	}
+
+	// keys are cleaned & prefixed
+	info, err := st.Put(ctx, "/docs/../docs/new.txt", strings.NewReader("hello"))
+	if assert.Nil(t, err) {
+		assert.Equal(t, "/docs/../docs/new.txt", info.Key)
+		assert.Equal(t, int64(5), info.Size)
+		assert.Equal(t, "etag", info.ETag)
+	}
+	assert.Equal(t, "hello", objects["p/docs/new.txt"])
+
+	body, info, err := st.Get(ctx, "docs/a.txt")
+	if assert.Nil(t, err) {
+		data, _ := io.ReadAll(body)
+		body.Close()
+		assert.Equal(t, "a", string(data))
+		assert.Equal(t, "text/plain", info.ContentType)
+	}
+
+	_, _, err = st.Get(ctx, "docs/missing.txt")
+	assert.Equal(t, ErrNotFound, err)
+
+	list, err := st.List(ctx, "docs/")
+	if assert.Nil(t, err) && assert.Len(t, list, 3) {
+		assert.Equal(t, "docs/a.txt", list[0].Key)
+		assert.Equal(t, "docs/b.txt", list[1].Key)
+		assert.Equal(t, "docs/new.txt", list[2].Key)
+	}
+
+	_, err = st.List(ctx, "../other/")
+	assert.Equal(t, errBadKey, err)
+
+	assert.Nil(t, st.Delete(ctx, "docs/new.txt"))
+	assert.Equal(t, []string{
+		"PUT /bucket/p/docs/new.txt",
+		"GET /bucket/p/docs/a.txt",
+		"GET /bucket/p/docs/missing.txt",
+		"GET /bucket",
+		"DELETE /bucket/p/docs/new.txt",
+	}, *requests)
+
+	// presigned with the static credentials, on the endpoint
+	getURL, err := st.PresignGet(ctx, "docs/a.txt", time.Minute)
+	if assert.Nil(t, err) {
+		u, _ := url.Parse(getURL)
+		assert.Equal(t, srv.URL+"/bucket/p/docs/a.txt", u.Scheme+"://"+u.Host+u.Path)
+		assert.Equal(t, "60", u.Query().Get("X-Amz-Expires"))
+		assert.True(t, strings.HasPrefix(u.Query().Get("X-Amz-Credential"), "AKID/"))
+	}
+
+	putURL, err := st.PresignPut(ctx, "docs/c.json", time.Minute, "application/json")
+	if assert.Nil(t, err) {
+		u, _ := url.Parse(putURL)
+		assert.Equal(t, "/bucket/p/docs/c.json", u.Path)
+		assert.Contains(t, u.Query().Get("X-Amz-SignedHeaders"), "content-type")
+	}
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/go-redis/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	tracerName = "github.com/fox-one/gin-contrib/session"
+
+	gormSpanKey = "session:span"
+)
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// gorm
+
+// gormBefore start a span parented to the statement context,
+// MysqlRead & MysqlWrite carry the session context into the statement
+func gormBefore(op string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		_, span := tracer().Start(ctx, "gorm."+op,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", db.Dialector.Name()),
+				attribute.String("db.operation", op),
+				attribute.String("db.sql.table", db.Statement.Table),
+			),
+		)
+
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func gormAfter(db *gorm.DB) {
+	v, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+
+	span, ok := v.(trace.Span)
+	if !ok {
+		return
+	}
+
+	span.SetAttributes(
+		attribute.String("db.statement", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+
+	if err := db.Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+func traceGorm(db *gorm.DB) {
+	cb := db.Callback()
+	cb.Create().Before("gorm:create").Register("session:before_create", gormBefore("create"))
+	cb.Create().After("gorm:create").Register("session:after_create", gormAfter)
+	cb.Query().Before("gorm:query").Register("session:before_query", gormBefore("query"))
+	cb.Query().After("gorm:query").Register("session:after_query", gormAfter)
+	cb.Update().Before("gorm:update").Register("session:before_update", gormBefore("update"))
+	cb.Update().After("gorm:update").Register("session:after_update", gormAfter)
+	cb.Delete().Before("gorm:delete").Register("session:before_delete", gormBefore("delete"))
+	cb.Delete().After("gorm:delete").Register("session:after_delete", gormAfter)
+	cb.Row().Before("gorm:row").Register("session:before_row", gormBefore("row"))
+	cb.Row().After("gorm:row").Register("session:after_row", gormAfter)
+	cb.Raw().Before("gorm:raw").Register("session:before_raw", gormBefore("raw"))
+	cb.Raw().After("gorm:raw").Register("session:after_raw", gormAfter)
+}
+
+// redis
+
+// redisHook trace redis commands, the span is parented to the context passed
+// to the command, a Session is a context so s.Redis().Get(s, key) works
+type redisHook struct{}
+
+func redisSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, attribute.String("db.system", "redis"))
+	return tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func endRedisSpan(span trace.Span, err error) {
+	if err != nil && err != redis.Nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+func (redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		ctx, span := redisSpan(ctx, "redis.dial", attribute.String("net.peer.name", addr))
+		conn, err := next(ctx, network, addr)
+		endRedisSpan(span, err)
+		return conn, err
+	}
+}
+
+func (redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		ctx, span := redisSpan(ctx, "redis."+cmd.Name(), attribute.String("db.operation", cmd.Name()))
+		err := next(ctx, cmd)
+		endRedisSpan(span, err)
+		return err
+	}
+}
+
+func (redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := redisSpan(ctx, "redis.pipeline", attribute.Int("db.redis.num_cmd", len(cmds)))
+		err := next(ctx, cmds)
+		if err == nil {
+			for _, cmd := range cmds {
+				if e := cmd.Err(); e != nil && e != redis.Nil {
+					err = e
+					break
+				}
+			}
+		}
+
+		endRedisSpan(span, err)
+		return err
+	}
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func newSpanRecorder(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+	})
+
+	return sr
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key string) attribute.Value {
+	for _, kv := range span.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value
+		}
+	}
+
+	return attribute.Value{}
+}
+
+func TestTraceGorm(t *testing.T) {
+	sr := newSpanRecorder(t)
+	s := newSqliteSession(t)
+	assert.Nil(t, s.DBWrite().AutoMigrate(&testUser{}))
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	s = s.WithContext(ctx)
+	assert.Nil(t, s.MysqlWrite().Create(&testUser{ID: 1, Name: "foo"}).Error)
+	assert.True(t, IsErrNotFound(s.MysqlRead().First(&testUser{}, 2).Error))
+	parent.End()
+
+	var spans []sdktrace.ReadOnlySpan
+	for _, span := range sr.Ended() {
+		if span.Parent().SpanID() == parent.SpanContext().SpanID() {
+			spans = append(spans, span)
+		}
+	}
+
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "gorm.create", spans[0].Name())
+		assert.Equal(t, "sqlite", spanAttr(spans[0], "db.system").AsString())
+		assert.Equal(t, "test_users", spanAttr(spans[0], "db.sql.table").AsString())
+		assert.Equal(t, int64(1), spanAttr(spans[0], "db.rows_affected").AsInt64())
+		assert.Contains(t, spanAttr(spans[0], "db.statement").AsString(), "INSERT INTO")
+
+		// not found isn't an error
+		assert.Equal(t, "gorm.query", spans[1].Name())
+		assert.Equal(t, codes.Unset, spans[1].Status().Code)
+	}
+
+	assert.NotNil(t, s.MysqlRead().Table("missing").Find(&[]testUser{}).Error)
+	spans = sr.Ended()
+	assert.Equal(t, codes.Error, spans[len(spans)-1].Status().Code)
+}
+
+func TestRedisHook(t *testing.T) {
+	sr := newSpanRecorder(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+
+	var hook redisHook
+	process := hook.ProcessHook(func(ctx context.Context, cmd redis.Cmder) error {
+		return cmd.Err()
+	})
+
+	cmd := redis.NewStringCmd(ctx, "get", "a")
+	cmd.SetErr(redis.Nil)
+	assert.Equal(t, redis.Nil, process(ctx, cmd))
+
+	cmd = redis.NewStringCmd(ctx, "set", "a", "1")
+	cmd.SetErr(errors.New("boom"))
+	assert.EqualError(t, process(ctx, cmd), "boom")
+
+	// a pipeline fails with its first failed command
+	pipeline := hook.ProcessPipelineHook(func(ctx context.Context, cmds []redis.Cmder) error {
+		return nil
+	})
+	get, set := redis.NewStringCmd(ctx, "get", "a"), redis.NewStatusCmd(ctx, "set", "a", "1")
+	get.SetErr(redis.Nil)
+	set.SetErr(errors.New("boom"))
+	assert.EqualError(t, pipeline(ctx, []redis.Cmder{get, set}), "boom")
+
+	spans := sr.Ended()
+	if assert.Len(t, spans, 3) {
+		for _, span := range spans {
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+			assert.Equal(t, "redis", spanAttr(span, "db.system").AsString())
+		}
+
+		assert.Equal(t, "redis.get", spans[0].Name())
+		assert.Equal(t, codes.Unset, spans[0].Status().Code)
+		assert.Equal(t, "redis.set", spans[1].Name())
+		assert.Equal(t, codes.Error, spans[1].Status().Code)
+		assert.Equal(t, "redis.pipeline", spans[2].Name())
+		assert.Equal(t, int64(2), spanAttr(spans[2], "db.redis.num_cmd").AsInt64())
+		assert.Equal(t, codes.Error, spans[2].Status().Code)
+	}
+}
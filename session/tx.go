@@ -0,0 +1,153 @@
+package session
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// txState transaction of a session, a savepoint has a parent
+type txState struct {
+	parent *txState
+	depth  int
+	// hooks run after the outermost transaction commits
+	hooks []func()
+}
+
+func (t *txState) commit() {
+	hooks := t.hooks
+	t.hooks = nil
+	for _, fn := range hooks {
+		fn()
+	}
+}
+
+// inTx db write is a transaction
+func (s *Session) inTx() bool {
+	if s.dbWrite == nil {
+		return false
+	}
+
+	tx, ok := s.dbWrite.Statement.ConnPool.(gorm.TxCommitter)
+	return ok && tx != nil
+}
+
+// Transaction run fn in a transaction on db write, commit if fn returns nil,
+// rollback if it returns an error or panics. Called inside a transaction it
+// uses a savepoint instead. Statements carry the session context, so the
+// deadline of WithContext applies to the whole transaction.
+// The outermost transaction is run again if it fails by a deadlock or a lock
+// wait timeout, see WithTxRetry, so fn must be safe to run more than once
+func (s *Session) Transaction(fn func(tx *Session) error) error {
+	if err := s.writeDB().Error; err != nil {
+		return err
+	}
+
+	if s.inTx() {
+		return s.savepoint(fn)
+	}
+
+	attempts, backoff := 1, time.Duration(0)
+	if o := s.opts; o != nil {
+		attempts, backoff = o.txRetries, o.txBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := s.transaction(fn)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		if attempt >= attempts {
+			recordTxRetry(s.Context(), err, true)
+			return err
+		}
+
+		recordTxRetry(s.Context(), err, false)
+
+		// jittered between backoff/2 and backoff*3/2
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff)+1))
+		select {
+		case <-time.After(wait):
+		case <-s.Done():
+			return err
+		}
+
+		backoff *= 2
+	}
+}
+
+func (s *Session) transaction(fn func(tx *Session) error) (err error) {
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	tx := s.DBBegin()
+	if err := tx.dbWrite.Error; err != nil {
+		return err
+	}
+
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			tx.tx.hooks = nil
+			if rbErr := tx.dbWrite.Rollback().Error; rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) && err == nil {
+				err = rbErr
+			}
+		}
+	}()
+
+	err = fn(tx)
+	panicked = false
+	if err == nil {
+		err = tx.DBCommit().Error
+	}
+
+	return err
+}
+
+func (s *Session) savepoint(fn func(tx *Session) error) (err error) {
+	parent := s.tx
+	if parent == nil {
+		parent = &txState{}
+	}
+
+	state := &txState{parent: parent, depth: parent.depth + 1}
+	name := fmt.Sprintf("sp%d", state.depth)
+	// on a new instance, errors of SavePoint & RollbackTo stay off the shared tx
+	if err := s.dbWrite.Session(&gorm.Session{}).SavePoint(name).Error; err != nil {
+		return err
+	}
+
+	panicked := true
+	defer func() {
+		if panicked || err != nil {
+			s.dbWrite.Session(&gorm.Session{}).RollbackTo(name)
+		}
+	}()
+
+	cp := s.Copy()
+	cp.tx = state
+	err = fn(cp)
+	panicked = false
+	if err == nil {
+		parent.hooks = append(parent.hooks, state.hooks...)
+	}
+
+	return err
+}
+
+// AfterCommit run fn once the outermost transaction commits, dropped on
+// rollback. Outside a transaction fn runs immediately
+func (s *Session) AfterCommit(fn func()) {
+	if s.tx == nil || !s.inTx() {
+		fn()
+		return
+	}
+
+	s.tx.hooks = append(s.tx.hooks, fn)
+}
@@ -0,0 +1,258 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// ConfigErrors every invalid key found by UnmarshalViper
+type ConfigErrors []*ConfigError
+
+func (err ConfigErrors) Error() string {
+	msgs := make([]string, len(err))
+	for idx, e := range err {
+		msgs[idx] = e.Key + ": " + e.Err.Error()
+	}
+
+	return "session: invalid config " + strings.Join(msgs, "; ")
+}
+
+func (err ConfigErrors) Unwrap() []error {
+	errs := make([]error, len(err))
+	for idx, e := range err {
+		errs[idx] = e
+	}
+
+	return errs
+}
+
+// errUnknownKey config key without a field
+var errUnknownKey = errors.New("unknown key")
+
+type unmarshalOptions struct {
+	strict bool
+}
+
+// UnmarshalOption option of UnmarshalViper
+type UnmarshalOption func(*unmarshalOptions)
+
+// Strict config keys without a field are an error, eg a misspelled key
+func Strict() UnmarshalOption {
+	return func(o *unmarshalOptions) {
+		o.strict = true
+	}
+}
+
+// AllowUnknownKeys ignore config keys without a field, the default, it
+// overrides a Strict before it
+func AllowUnknownKeys() UnmarshalOption {
+	return func(o *unmarshalOptions) {
+		o.strict = false
+	}
+}
+
+// configKey key under prefix
+func configKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+
+	return prefix + "." + key
+}
+
+// fieldKey config key of f, as mapstructure matches it
+func fieldKey(f reflect.StructField, tag string) string {
+	name := strings.SplitN(f.Tag.Get(tag), ",", 2)[0]
+	if name == "" {
+		name = f.Name
+	}
+
+	return strings.ToLower(name)
+}
+
+var validators sync.Map
+
+// validatorFor validator naming fields by their config key
+func validatorFor(tag string) *validator.Validate {
+	if v, ok := validators.Load(tag); ok {
+		return v.(*validator.Validate)
+	}
+
+	v := validator.New()
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		if name := fieldKey(f, tag); name != "-" {
+			return name
+		}
+
+		return ""
+	})
+
+	actual, _ := validators.LoadOrStore(tag, v)
+	return actual.(*validator.Validate)
+}
+
+// decodeDefault decode the default value s into ptr as viper decodes config values
+func decodeDefault(s string, ptr interface{}) error {
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           ptr,
+	})
+	if err != nil {
+		return err
+	}
+
+	return d.Decode(s)
+}
+
+// defaults set the zero fields with a default tag, nested structs included
+type defaults struct {
+	tag    string
+	prefix string
+	// isSet the config has the key, relative to prefix
+	isSet func(key string) bool
+}
+
+// apply defaults to the struct v at the config key path. A nil pointer to a
+// struct is allocated if the config has its key, so that its defaults apply
+// too, it stays nil otherwise
+func (d *defaults) apply(v reflect.Value, path string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		fv := v.Field(i)
+		key := configKey(path, fieldKey(f, d.tag))
+		switch {
+		case fv.Kind() == reflect.Struct:
+			if err := d.apply(fv, key); err != nil {
+				return err
+			}
+		case fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct:
+			if fv.IsNil() && d.isSet(key) {
+				fv.Set(reflect.New(fv.Type().Elem()))
+			}
+
+			if !fv.IsNil() {
+				if err := d.apply(fv.Elem(), key); err != nil {
+					return err
+				}
+			}
+		}
+
+		def, ok := f.Tag.Lookup("default")
+		if !ok || !fv.IsZero() {
+			continue
+		}
+
+		if err := decodeDefault(def, fv.Addr().Interface()); err != nil {
+			return &ConfigError{Key: configKey(d.prefix, key), Err: fmt.Errorf("bad default %q: %w", def, err)}
+		}
+	}
+
+	return nil
+}
+
+// validationErrors ConfigErrors keyed by the full config key
+func validationErrors(errs validator.ValidationErrors, prefix string) ConfigErrors {
+	out := make(ConfigErrors, len(errs))
+	for idx, fe := range errs {
+		// the namespace starts with the struct name
+		key := fe.Namespace()
+		if i := strings.Index(key, "."); i >= 0 {
+			key = key[i+1:]
+		}
+
+		rule := fe.Tag()
+		if fe.Param() != "" {
+			rule += "=" + fe.Param()
+		}
+
+		out[idx] = &ConfigError{Key: configKey(prefix, key), Err: fmt.Errorf("failed on %s", rule)}
+	}
+
+	return out
+}
+
+// UnmarshalViperWithTag decode the config into the struct r, fields are
+// matched by tag. Zero fields get the value of their default tag first.
+// With Strict, keys without a field are an error. Then fields are checked
+// by their validate tag, see go-playground/validator.
+// Errors are ConfigErrors keyed by the full config key
+func (s *Session) UnmarshalViperWithTag(r interface{}, tag string, opts ...UnmarshalOption) error {
+	o := &unmarshalOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	v := s.Viper()
+	if v == nil {
+		v = viper.New()
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(r))
+	isStruct := rv.Kind() == reflect.Struct
+	if isStruct {
+		d := &defaults{tag: tag, prefix: s.prefix, isSet: v.IsSet}
+		if err := d.apply(rv, ""); err != nil {
+			return err
+		}
+	}
+
+	var md mapstructure.Metadata
+	err := v.Unmarshal(r, func(opt *mapstructure.DecoderConfig) {
+		opt.TagName = tag
+		opt.Metadata = &md
+	})
+	if err != nil {
+		if s.prefix != "" {
+			return &ConfigError{Key: s.prefix, Err: err}
+		}
+
+		return err
+	}
+
+	if o.strict && len(md.Unused) > 0 {
+		sort.Strings(md.Unused)
+		errs := make(ConfigErrors, len(md.Unused))
+		for idx, key := range md.Unused {
+			errs[idx] = &ConfigError{Key: configKey(s.prefix, key), Err: errUnknownKey}
+		}
+
+		return errs
+	}
+
+	if !isStruct {
+		return nil
+	}
+
+	if err := validatorFor(tag).Struct(r); err != nil {
+		var verrs validator.ValidationErrors
+		if errors.As(err, &verrs) {
+			return validationErrors(verrs, s.prefix)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// UnmarshalViper UnmarshalViperWithTag with the json tag
+func (s *Session) UnmarshalViper(r interface{}, opts ...UnmarshalOption) error {
+	return s.UnmarshalViperWithTag(r, "json", opts...)
+}
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testServerConfig struct {
+	Addr    string        `json:"addr" validate:"required"`
+	Timeout time.Duration `json:"timeout" default:"5s"`
+	Hosts   []string      `json:"hosts" default:"a,b"`
+	Limits  struct {
+		Rate  int `json:"rate" default:"10" validate:"min=1"`
+		Burst int `json:"burst" default:"20"`
+	} `json:"limits"`
+	TLS *struct {
+		Cert string `json:"cert" default:"server.pem"`
+		Key  string `json:"key"`
+	} `json:"tls"`
+	Backup *struct {
+		Addr string `json:"addr" default:":9090"`
+	} `json:"backup"`
+	Writer io.Writer `json:"-"`
+}
+
+func TestUnmarshalViper(t *testing.T) {
+	s, err := New([]byte(`
+app:
+  server:
+    addr: ":8080"
+    limits:
+      burst: 5
+    tls:
+      key: server.key
+    unknown: 1
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	var cfg testServerConfig
+	assert.Nil(t, s.SubViper("app.server").UnmarshalViper(&cfg))
+	assert.Equal(t, ":8080", cfg.Addr)
+	assert.Equal(t, 5*time.Second, cfg.Timeout)
+	assert.Equal(t, []string{"a", "b"}, cfg.Hosts)
+	assert.Equal(t, 10, cfg.Limits.Rate)
+	assert.Equal(t, 5, cfg.Limits.Burst)
+	// nil pointers get defaults if the config has their key
+	if assert.NotNil(t, cfg.TLS) {
+		assert.Equal(t, "server.pem", cfg.TLS.Cert)
+		assert.Equal(t, "server.key", cfg.TLS.Key)
+	}
+	assert.Nil(t, cfg.Backup)
+
+	s, err = New([]byte(`
+app:
+  server:
+    adr: ":8080"
+    limits:
+      rate: 0
+      brust: 5
+`))
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	var errs ConfigErrors
+	err = s.SubViper("app").SubViper("server").UnmarshalViper(&testServerConfig{}, Strict())
+	if assert.True(t, errors.As(err, &errs)) {
+		assert.Equal(t, "session: invalid config app.server.adr: unknown key; app.server.limits.brust: unknown key", err.Error())
+	}
+
+	err = s.SubViper("app.server").UnmarshalViper(&testServerConfig{}, Strict(), AllowUnknownKeys())
+	if assert.True(t, errors.As(err, &errs)) && assert.Len(t, errs, 2) {
+		assert.Equal(t, "app.server.addr", errs[0].Key)
+		assert.Equal(t, "app.server.limits.rate", errs[1].Key)
+		assert.Equal(t, "failed on min=1", errs[1].Err.Error())
+	}
+
+	var bad struct {
+		Port int `json:"port" default:"http"`
+	}
+	var cerr *ConfigError
+	if assert.True(t, errors.As(s.UnmarshalViper(&bad), &cerr)) {
+		assert.Equal(t, "port", cerr.Key)
+	}
+}
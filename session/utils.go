@@ -1,98 +1,406 @@
 package session
 
 import (
+	"context"
+	"crypto/tls"
+	"database/sql"
 	"fmt"
-	"log"
+	"strings"
+	"sync"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/go-redis/redis"
-	_ "github.com/go-sql-driver/mysql"
-	"github.com/jinzhu/gorm"
 	"github.com/spf13/viper"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
-func dialMysql(host, user, pwd, database string) *gorm.DB {
-	path := fmt.Sprintf("%s:%s@%s(%s)/%s?parseTime=True&charset=utf8mb4",
-		user,
-		pwd,
-		"tcp",
-		host,
-		database,
-	)
+func dialDB(d dialect, host string, cfg *dbConfig, o *options) (*sql.DB, error) {
+	db, err := sql.Open(d.driver, d.dsn(host, cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if !o.lazy {
+		if err := o.retry(db.Ping); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("connect %s %s failed: %w", d.driver, host, err)
+		}
+	}
+
+	return db, nil
+}
+
+// namedPool pool with the backend name reported by Health
+type namedPool struct {
+	name string
+	*sql.DB
+}
+
+// openGorm open gorm on the write pool, reads are balanced across the
+// replicas by the dbresolver plugin
+func openGorm(d dialect, write *sql.DB, rs *replicaSet, o *options) (*gorm.DB, error) {
+	db, err := gorm.Open(d.gorm(write, o.lazy), &gorm.Config{DisableAutomaticPing: o.lazy})
+	if err != nil {
+		return nil, err
+	}
+
+	if rs != nil {
+		var replicas []gorm.Dialector
+		for _, r := range rs.replicas {
+			replicas = append(replicas, d.gorm(r.DB, o.lazy))
+		}
+
+		resolver := dbresolver.Register(dbresolver.Config{
+			Replicas: append(replicas, d.gorm(write, o.lazy)),
+			Policy:   rs,
+		})
+
+		if err := db.Use(resolver); err != nil {
+			return nil, err
+		}
+	}
+
+	traceGorm(db)
+	return db, nil
+}
+
+// backendName name reported by Health, eg db_read for the default
+// instance and db_read.analytics for the analytics instance
+func backendName(base, instance, _default string) string {
+	if instance == _default {
+		return base
+	}
+
+	return base + "." + instance
+}
+
+// backends every opened instance, shared by session copies
+type backends struct {
+	mu sync.RWMutex
+	// v config of the backends, replaced by reloads
+	v            *viper.Viper
+	dbs          map[string]*dbInstance
+	defaultDB    string
+	redises      map[string]redis.UniversalClient
+	defaultRedis string
+	aws          *session.Session
+	awsCfg       *aws.Config
+	storage      Storage
+
+	// watch state, see Watch
+	watch *watchState
+}
+
+// viper the current config
+func (b *backends) viper() *viper.Viper {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.v
+}
+
+// db the named instance, the default one if name is empty
+func (b *backends) db(name string) *dbInstance {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if name == "" {
+		name = b.defaultDB
+	}
+
+	return b.dbs[name]
+}
+
+// redis the named instance, the default one if name is empty
+func (b *backends) redis(name string) redis.UniversalClient {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if name == "" {
+		name = b.defaultRedis
+	}
+
+	return b.redises[name]
+}
+
+func (b *backends) awsSession() *session.Session {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.aws
+}
+
+func (b *backends) awsConfig() *aws.Config {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.awsCfg
+}
+
+func (b *backends) objectStorage() Storage {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.storage
+}
+
+func (b *backends) close() {
+	if b.watch != nil {
+		b.watch.close()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 
-	db, err := gorm.Open("mysql", path)
+	closeRedises(b.redises)
+	closeDBs(b.dbs)
+}
+
+func closeRedises(clients map[string]redis.UniversalClient) {
+	for _, c := range clients {
+		c.Close()
+	}
+}
+
+func closeDBs(dbs map[string]*dbInstance) {
+	for _, db := range dbs {
+		db.close()
+	}
+}
+
+// dbInstance a configured database with read/write splitting
+type dbInstance struct {
+	dialect dialect
+	read    *gorm.DB
+	write   *gorm.DB
+	// pools the write pool comes first
+	pools    []*namedPool
+	replicas *replicaSet
+}
+
+func (db *dbInstance) close() {
+	if db.replicas != nil {
+		db.replicas.close()
+	}
+
+	for _, p := range db.pools {
+		p.Close()
+	}
+}
+
+// openDBInstance for sqlite read & write are file paths, db is used if read is empty.
+// Every read host other than write is a replica
+func openDBInstance(cfg *dbConfig, _default string, o *options) (inst *dbInstance, err error) {
+	d := cfg.dialect
+	inst = &dbInstance{dialect: d}
+	defer func() {
+		if err != nil {
+			inst.close()
+			inst = nil
+		}
+	}()
+
+	reads, write := cfg.Reads, cfg.Write
+	if len(reads) == 0 && d.driver == sqlite.DriverName {
+		reads = []string{cfg.DB}
+	}
+
+	if write == "" && len(reads) > 0 {
+		write = reads[0]
+	}
+
+	dial := func(host string) (*sql.DB, error) {
+		pool, err := dialDB(d, host, cfg, o)
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(host, ":memory:") {
+			// every connection opens its own in-memory database
+			pool.SetMaxOpenConns(1)
+		}
+
+		return pool, nil
+	}
+
+	writePool, err := dial(write)
+	if err != nil {
+		return
+	}
+	inst.pools = append(inst.pools, &namedPool{backendName("db", cfg.Name, _default), writePool})
+
+	var hosts []string
+	for _, host := range reads {
+		if host != write {
+			hosts = append(hosts, host)
+		}
+	}
+
+	if len(hosts) > 0 {
+		inst.pools[0].name = backendName("db_write", cfg.Name, _default)
+		inst.replicas = &replicaSet{
+			dialect:     d,
+			policy:      cfg.ReadPolicy,
+			maxLag:      cfg.MaxReplicationLag,
+			maxFailures: cfg.ReplicaMaxFailures,
+			write:       writePool,
+		}
+	}
+
+	for idx, host := range hosts {
+		var pool *sql.DB
+		if pool, err = dial(host); err != nil {
+			return
+		}
+
+		name := "db_read"
+		if len(hosts) > 1 {
+			name = fmt.Sprintf("db_read_%d", idx)
+		}
+
+		p := &namedPool{backendName(name, cfg.Name, _default), pool}
+		inst.pools = append(inst.pools, p)
+		inst.replicas.replicas = append(inst.replicas.replicas, &replica{namedPool: p, lag: -1})
+	}
+
+	g, err := openGorm(d, writePool, inst.replicas, o)
 	if err != nil {
-		log.Panicf("Connect mysql %s failed: %s", host, err)
+		return
 	}
 
-	db.DB().SetMaxIdleConns(10)
-	return db
+	if rs := inst.replicas; rs != nil {
+		for _, r := range rs.replicas {
+			if r.db, err = gorm.Open(d.gorm(r.DB, o.lazy), &gorm.Config{DisableAutomaticPing: o.lazy}); err != nil {
+				return
+			}
+			traceGorm(r.db)
+		}
+
+		rs.run(cfg.ReplicaCheckInterval)
+	}
+
+	inst.read = g.Clauses(dbresolver.Read).Session(&gorm.Session{})
+	inst.write = g.Clauses(dbresolver.Write).Session(&gorm.Session{})
+	return inst, nil
 }
 
-func openMysql(v *viper.Viper) (r, w *gorm.DB) {
-	v = v.Sub("mysql")
-	if v == nil {
-		return nil, nil
+// openDBs open every db instance, errors are collected per instance
+func openDBs(v *viper.Viper, o *options, errs *OpenError) (dbs map[string]*dbInstance, _default string) {
+	cfgs, _default, err := loadDBConfigs(v)
+	if err != nil {
+		*errs = append(*errs, &BackendError{Backend: "db", Err: err})
+		return nil, ""
 	}
 
-	var (
-		username = v.GetString("username")
-		password = v.GetString("password")
-		db       = v.GetString("db")
-		read     = v.GetString("read")
-		write    = v.GetString("write")
-	)
+	dbs = make(map[string]*dbInstance, len(cfgs))
+	for name, cfg := range cfgs {
+		inst, err := openDBInstance(cfg, _default, o)
+		if err != nil {
+			*errs = append(*errs, &BackendError{Backend: backendName("db", name, _default), Err: err})
+			continue
+		}
 
-	r = dialMysql(read, username, password, db)
-	if write == "" || write == read {
-		w = r
-	} else {
-		w = dialMysql(write, username, password, db)
+		dbs[name] = inst
 	}
 
-	return
+	return dbs, _default
 }
 
-func openRedis(v *viper.Viper) *redis.Client {
-	v = v.Sub("redis")
-	if v == nil {
-		return nil
+func newRedisClient(cfg *redisConfig) redis.UniversalClient {
+	var tlsConfig *tls.Config
+	if cfg.TLS {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
 	}
 
-	var (
-		addr = v.GetString("addr")
-		db   = v.GetInt("db")
-	)
+	switch cfg.Mode {
+	case RedisModeSentinel:
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         cfg.PoolSize,
+			MinIdleConns:     cfg.MinIdleConns,
+			DialTimeout:      cfg.DialTimeout,
+			ReadTimeout:      cfg.ReadTimeout,
+			WriteTimeout:     cfg.WriteTimeout,
+			PoolTimeout:      cfg.PoolTimeout,
+		})
+	case RedisModeCluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Password:     cfg.Password,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolTimeout:  cfg.PoolTimeout,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:         cfg.Addr,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			TLSConfig:    tlsConfig,
+			PoolSize:     cfg.PoolSize,
+			MinIdleConns: cfg.MinIdleConns,
+			DialTimeout:  cfg.DialTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+			PoolTimeout:  cfg.PoolTimeout,
+		})
+	}
+}
 
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: "",
-		DB:       db,
-	})
+func openRedis(cfg *redisConfig, o *options) (redis.UniversalClient, error) {
+	client := newRedisClient(cfg)
+	if !o.lazy {
+		err := o.retry(func() error {
+			return client.Ping(context.Background()).Err()
+		})
 
-	if err := client.Ping().Err(); err != nil {
-		panic(err)
+		if err != nil {
+			addr := cfg.Addr
+			if len(cfg.Addrs) > 0 {
+				addr = strings.Join(cfg.Addrs, ",")
+			}
+
+			client.Close()
+			return nil, fmt.Errorf("connect redis %s failed: %w", addr, err)
+		}
 	}
 
-	return client
+	client.AddHook(redisHook{})
+
+	return client, nil
 }
 
-func awsSession(v *viper.Viper) *session.Session {
-	v = v.Sub("aws")
-	if v == nil {
-		return nil
+// openRedises open every redis instance, errors are collected per instance
+func openRedises(v *viper.Viper, o *options, errs *OpenError) (clients map[string]redis.UniversalClient, _default string) {
+	cfgs, _default, err := loadRedisConfigs(v)
+	if err != nil {
+		*errs = append(*errs, &BackendError{Backend: "redis", Err: err})
+		return nil, ""
 	}
 
-	var (
-		key    = v.GetString("key")
-		secret = v.GetString("secret")
-		region = v.GetString("region")
-	)
+	clients = make(map[string]redis.UniversalClient, len(cfgs))
+	for name, cfg := range cfgs {
+		client, err := openRedis(cfg, o)
+		if err != nil {
+			*errs = append(*errs, &BackendError{Backend: backendName("redis", name, _default), Err: err})
+			continue
+		}
+
+		clients[name] = client
+	}
 
-	return session.New(&aws.Config{
-		Region:      aws.String(region),
-		Credentials: credentials.NewStaticCredentials(key, secret, ""),
-	})
+	return clients, _default
 }
@@ -0,0 +1,505 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// ErrNoConfigFile the session wasn't read from a file, see NewWithFile
+var ErrNoConfigFile = errors.New("session: no config file")
+
+// DrainTimeout time the backends replaced by a reload keep serving the handles
+// taken before it, eg a transaction or a client returned by Redis
+var DrainTimeout = 30 * time.Second
+
+// watchDebounce wait for the writes of an update to settle before reloading
+const watchDebounce = 100 * time.Millisecond
+
+// sections whose changes rebuild backends
+var (
+	redisSections = []string{"redis"}
+	dbSections    = []string{"mysql", "postgres", "sqlite"}
+	awsSections   = []string{"aws"}
+	// storage on s3 depends on the aws section
+	storageSections = []string{"storage", "aws"}
+)
+
+// Change change of a config key by a reload, Old is nil for an added key and
+// New for a removed one
+type Change struct {
+	Key string
+	Old interface{}
+	New interface{}
+}
+
+// Removed the key is gone
+func (c Change) Removed() bool {
+	return c.New == nil
+}
+
+// String new value as a string
+func (c Change) String() string {
+	return cast.ToString(c.New)
+}
+
+// Int new value as an int
+func (c Change) Int() int {
+	return cast.ToInt(c.New)
+}
+
+// Bool new value as a bool
+func (c Change) Bool() bool {
+	return cast.ToBool(c.New)
+}
+
+// Duration new value as a duration
+func (c Change) Duration() time.Duration {
+	return cast.ToDuration(c.New)
+}
+
+// StringSlice new value as a string slice
+func (c Change) StringSlice() []string {
+	return cast.ToStringSlice(c.New)
+}
+
+type subscription struct {
+	key string
+	fn  func(Change)
+}
+
+func (sub *subscription) match(key string) bool {
+	return sub.key == "" || key == sub.key || strings.HasPrefix(key, sub.key+".")
+}
+
+// watchState reload state shared by session copies
+type watchState struct {
+	// mu serializes reloads
+	mu sync.Mutex
+	// file config file, empty if the config wasn't read from a file
+	file string
+	// settings flattened config of the last reload
+	settings map[string]interface{}
+
+	subsMu sync.Mutex
+	subs   map[int]*subscription
+	nextID int
+
+	// notifyMu guards the changes of reloads not notified yet, queued in
+	// reload order and delivered by a single goroutine at a time
+	notifyMu  sync.Mutex
+	pending   [][]Change
+	notifying bool
+
+	watcher *fsnotify.Watcher
+	// drains close the replaced backends, run early by Close. An entry is
+	// removed once it has run
+	drains    map[int]func()
+	nextDrain int
+}
+
+func newWatchState(v *viper.Viper) *watchState {
+	return &watchState{
+		file:     v.ConfigFileUsed(),
+		settings: flattenSettings(v.AllSettings(), "", nil),
+		subs:     make(map[int]*subscription),
+		drains:   make(map[int]func()),
+	}
+}
+
+// flattenSettings leaves of m by their dotted key
+func flattenSettings(m map[string]interface{}, path string, out map[string]interface{}) map[string]interface{} {
+	if out == nil {
+		out = make(map[string]interface{})
+	}
+
+	for k, val := range m {
+		key := k
+		if path != "" {
+			key = path + "." + k
+		}
+
+		if sub, ok := val.(map[string]interface{}); ok {
+			flattenSettings(sub, key, out)
+			continue
+		}
+
+		out[key] = val
+	}
+
+	return out
+}
+
+// diffSettings changes from old to cur, sorted by key
+func diffSettings(old, cur map[string]interface{}) []Change {
+	var changes []Change
+	for key, val := range cur {
+		if prev, ok := old[key]; !ok || !reflect.DeepEqual(prev, val) {
+			changes = append(changes, Change{Key: key, Old: prev, New: val})
+		}
+	}
+
+	for key, prev := range old {
+		if _, ok := cur[key]; !ok {
+			changes = append(changes, Change{Key: key, Old: prev})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+
+	return changes
+}
+
+// changed any change is in one of sections
+func changed(changes []Change, sections []string) bool {
+	for _, c := range changes {
+		for _, section := range sections {
+			if c.Key == section || strings.HasPrefix(c.Key, section+".") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// NewWithFile new session with the config file at path, the format is told
+// by the extension. The session can Reload or Watch it
+func NewWithFile(path string, opts ...Option) (*Session, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	return Open(v, opts...)
+}
+
+// Subscribe call fn for every change of key, or of the keys under it, on
+// reloads, eg "redis" for any redis key. Affected backends are rebuilt before
+// fn is called, out of the reload lock so fn may use the session freely.
+// Changes are delivered one at a time in reload order, those of a Reload
+// called by fn follow once fn returns. cancel stops the subscription
+func (s *Session) Subscribe(key string, fn func(Change)) (cancel func()) {
+	w := s.backends.watch
+	w.subsMu.Lock()
+	defer w.subsMu.Unlock()
+
+	id := w.nextID
+	w.nextID++
+	w.subs[id] = &subscription{key: key, fn: fn}
+
+	return func() {
+		w.subsMu.Lock()
+		defer w.subsMu.Unlock()
+		delete(w.subs, id)
+	}
+}
+
+// notify deliver the queued changes in reload order. If another call is
+// delivering, eg a subscriber reloaded, it delivers them after the current
+// ones instead
+func (w *watchState) notify() {
+	w.notifyMu.Lock()
+	if w.notifying {
+		w.notifyMu.Unlock()
+		return
+	}
+	w.notifying = true
+
+	defer func() {
+		w.notifyMu.Lock()
+		w.notifying = false
+		w.notifyMu.Unlock()
+	}()
+
+	for len(w.pending) > 0 {
+		changes := w.pending[0]
+		w.pending = w.pending[1:]
+		w.notifyMu.Unlock()
+
+		w.deliver(changes)
+		w.notifyMu.Lock()
+	}
+	w.notifyMu.Unlock()
+}
+
+func (w *watchState) deliver(changes []Change) {
+	w.subsMu.Lock()
+	subs := make([]*subscription, 0, len(w.subs))
+	for _, sub := range w.subs {
+		subs = append(subs, sub)
+	}
+	w.subsMu.Unlock()
+
+	for _, c := range changes {
+		for _, sub := range subs {
+			if sub.match(c.Key) {
+				sub.fn(c)
+			}
+		}
+	}
+}
+
+// Reload read the config file again. The new config is validated and the
+// backends of changed sections are opened first, on any error the session
+// keeps the previous config & backends. The config & backends are swapped
+// together, replaced backends are closed after DrainTimeout. Changes of the
+// memory section aren't applied
+func (s *Session) Reload() error {
+	w := s.backends.watch
+	if err := s.reload(w); err != nil {
+		return err
+	}
+
+	w.notify()
+	return nil
+}
+
+// reload swap in the config file and queue its changes, holding the reload lock
+func (s *Session) reload(w *watchState) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == "" {
+		return ErrNoConfigFile
+	}
+
+	data, err := os.ReadFile(w.file)
+	if err != nil {
+		return err
+	}
+
+	// a new viper, the current one may be read meanwhile
+	next := viper.New()
+	next.SetConfigFile(w.file)
+	next.SetConfigType(strings.TrimPrefix(filepath.Ext(w.file), "."))
+	if err := next.ReadConfig(bytes.NewReader(data)); err != nil {
+		return err
+	}
+
+	if next, err = applyEnv(next, s.opts); err != nil {
+		return err
+	}
+
+	if err := validateConfig(next); err != nil {
+		return err
+	}
+
+	settings := flattenSettings(next.AllSettings(), "", nil)
+	changes := diffSettings(w.settings, settings)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := s.backends.rebuild(next, s.opts, changes); err != nil {
+		return err
+	}
+
+	w.settings = settings
+	w.notifyMu.Lock()
+	w.pending = append(w.pending, changes)
+	w.notifyMu.Unlock()
+	return nil
+}
+
+// rebuild open the backends of the changed sections on v and swap them in
+// with v, the replaced ones are drained
+func (b *backends) rebuild(v *viper.Viper, o *options, changes []Change) error {
+	var (
+		errs         OpenError
+		redises, dbs = changed(changes, redisSections), changed(changes, dbSections)
+	)
+
+	next := &backends{}
+	if redises {
+		next.redises, next.defaultRedis = openRedises(v, o, &errs)
+	}
+
+	if dbs {
+		next.dbs, next.defaultDB = openDBs(v, o, &errs)
+	}
+
+	aws := changed(changes, awsSections)
+	if aws {
+		cfg, err := openAWS(v)
+		if err != nil {
+			errs = append(errs, &BackendError{Backend: "aws", Err: err})
+		}
+		next.aws, next.awsCfg = awsSession(v), cfg
+	}
+
+	storage := changed(changes, storageSections)
+	if storage {
+		awsCfg := next.awsCfg
+		if !aws {
+			awsCfg = b.awsConfig()
+		}
+
+		st, err := openStorage(v, awsCfg)
+		if err != nil {
+			errs = append(errs, &BackendError{Backend: "storage", Err: err})
+		}
+		next.storage = st
+	}
+
+	if len(errs) > 0 {
+		next.close()
+		return errs
+	}
+
+	b.mu.Lock()
+	b.v = v
+	old := &backends{}
+	if redises {
+		old.redises, b.redises, b.defaultRedis = b.redises, next.redises, next.defaultRedis
+	}
+
+	if dbs {
+		old.dbs, b.dbs, b.defaultDB = b.dbs, next.dbs, next.defaultDB
+	}
+
+	if aws {
+		b.aws, b.awsCfg = next.aws, next.awsCfg
+	}
+
+	if storage {
+		b.storage = next.storage
+	}
+	b.mu.Unlock()
+
+	b.watch.drain(old.close)
+	return nil
+}
+
+// drain run fn after DrainTimeout, or on Close
+func (w *watchState) drain(fn func()) {
+	w.subsMu.Lock()
+	defer w.subsMu.Unlock()
+
+	id := w.nextDrain
+	w.nextDrain++
+
+	var once sync.Once
+	run := func() {
+		once.Do(fn)
+
+		w.subsMu.Lock()
+		delete(w.drains, id)
+		w.subsMu.Unlock()
+	}
+	w.drains[id] = run
+
+	time.AfterFunc(DrainTimeout, run)
+}
+
+// Watch reload the config file whenever it changes, see Reload. Failed
+// reloads are logged
+func (s *Session) Watch() error {
+	w := s.backends.watch
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	file := w.file
+	if file == "" {
+		return ErrNoConfigFile
+	}
+
+	if w.watcher != nil {
+		return nil
+	}
+
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// the directory is watched, editors & config maps replace the file
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	w.watcher = watcher
+	go s.watch(watcher, file)
+	return nil
+}
+
+func (s *Session) watch(watcher *fsnotify.Watcher, file string) {
+	realPath, _ := filepath.EvalSymlinks(file)
+	reload := func() {
+		if err := s.Reload(); err != nil {
+			log.Printf("session: reload %s failed: %v", file, err)
+		}
+	}
+
+	timer := time.AfterFunc(time.Hour, reload)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+
+			cur, _ := filepath.EvalSymlinks(file)
+			switch {
+			case cur != "" && cur != realPath:
+				// a config map swaps the link target instead of writing the file
+			case filepath.Clean(ev.Name) == file && ev.Op&(fsnotify.Write|fsnotify.Create) != 0:
+			default:
+				continue
+			}
+
+			realPath = cur
+			timer.Reset(watchDebounce)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+
+			log.Printf("session: watch %s: %v", file, err)
+		}
+	}
+}
+
+// close stop watching and close the drained backends
+func (w *watchState) close() {
+	w.mu.Lock()
+	if w.watcher != nil {
+		w.watcher.Close()
+		w.watcher = nil
+	}
+	w.mu.Unlock()
+
+	w.subsMu.Lock()
+	drains := make([]func(), 0, len(w.drains))
+	for _, fn := range w.drains {
+		drains = append(drains, fn)
+	}
+	w.subsMu.Unlock()
+
+	for _, fn := range drains {
+		fn()
+	}
+}
@@ -0,0 +1,198 @@
+package session
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReload(t *testing.T) {
+	a, b := miniredis.RunT(t), miniredis.RunT(t)
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(addr, name string) {
+		data := "redis:\n  addr: " + addr + "\napp:\n  name: " + name + "\n"
+		assert.Nil(t, os.WriteFile(file, []byte(data), 0600))
+	}
+
+	defer func(timeout time.Duration) { DrainTimeout = timeout }(DrainTimeout)
+	DrainTimeout = 50 * time.Millisecond
+
+	write(a.Addr(), "foo")
+	s, err := NewWithFile(file)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	c := NewCache(s, "users")
+	defer c.Close()
+
+	var (
+		mu      sync.Mutex
+		changes []Change
+	)
+	s.Subscribe("app", func(c Change) {
+		mu.Lock()
+		changes = append(changes, c)
+		mu.Unlock()
+	})
+
+	old := s.Redis()
+	write(b.Addr(), "bar")
+	assert.Nil(t, s.Reload())
+
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, "app.name", changes[0].Key)
+		assert.Equal(t, "foo", changes[0].Old)
+		assert.Equal(t, "bar", changes[0].String())
+	}
+	assert.Equal(t, "bar", s.Viper().GetString("app.name"))
+
+	// copies follow the new redis, the old one is drained
+	assert.NotEqual(t, old, s.Copy().Redis())
+	assert.Nil(t, c.Set(ctx, "1", "alice"))
+	assert.True(t, b.Exists("cache:users:1"))
+	assert.False(t, a.Exists("cache:users:1"))
+	assert.Eventually(t, func() bool {
+		return old.Ping(ctx).Err() != nil
+	}, time.Second, 10*time.Millisecond)
+
+	// an invalid config is rejected, the previous one is kept
+	assert.Nil(t, os.WriteFile(file, []byte("memory:\n  eviction: fifo\n"), 0600))
+	assert.NotNil(t, s.Reload())
+	assert.Equal(t, "bar", s.Viper().GetString("app.name"))
+	assert.Nil(t, s.Redis().Ping(ctx).Err())
+
+	assert.Nil(t, s.Watch())
+	write(b.Addr(), "baz")
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(changes) == 2 && changes[1].String() == "baz"
+	}, 2*time.Second, 10*time.Millisecond)
+
+	s, err = New([]byte("app:\n  name: foo\n"))
+	if assert.Nil(t, err) {
+		defer s.Close()
+		assert.Equal(t, ErrNoConfigFile, s.Reload())
+		assert.Equal(t, ErrNoConfigFile, s.Watch())
+	}
+}
+
+func TestReloadConcurrentReads(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(name string) {
+		assert.Nil(t, os.WriteFile(file, []byte("app:\n  name: "+name+"\n  port: 80\n"), 0600))
+	}
+
+	write("foo")
+	s, err := NewWithFile(file)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	// subscribers run out of the reload lock, they may reload
+	reloaded := make(chan error, 1)
+	s.Subscribe("app.name", func(c Change) {
+		if c.String() == "bar" {
+			reloaded <- s.Reload()
+		}
+	})
+
+	sub := s.SubViper("app")
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				s.Viper().GetString("app.name")
+				sub.Viper().GetInt("port")
+			}
+		}
+	}()
+
+	write("bar")
+	assert.Nil(t, s.Reload())
+	assert.Nil(t, <-reloaded)
+	close(done)
+	wg.Wait()
+
+	assert.Equal(t, "bar", s.Viper().GetString("app.name"))
+	assert.Equal(t, "bar", sub.Viper().GetString("name"))
+}
+
+func TestReloadOrder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	// renamed into place, reloads never read a partial file
+	write := func(n int) {
+		tmp := file + ".tmp"
+		assert.Nil(t, os.WriteFile(tmp, []byte("app:\n  n: "+strconv.Itoa(n)+"\n"), 0600))
+		assert.Nil(t, os.Rename(tmp, file))
+	}
+
+	defer func(timeout time.Duration) { DrainTimeout = timeout }(DrainTimeout)
+	DrainTimeout = time.Millisecond
+
+	write(0)
+	s, err := NewWithFile(file)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+
+	var (
+		mu   sync.Mutex
+		seen []int
+	)
+	s.Subscribe("app.n", func(c Change) {
+		mu.Lock()
+		seen = append(seen, c.Int())
+		mu.Unlock()
+	})
+
+	// concurrent reloads notify in the order they swapped the config
+	var wg sync.WaitGroup
+	for n := 1; n <= 20; n++ {
+		write(n)
+		wg.Add(2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer wg.Done()
+				s.Reload()
+			}()
+		}
+	}
+	wg.Wait()
+	assert.Nil(t, s.Reload())
+
+	mu.Lock()
+	assert.IsIncreasing(t, seen)
+	assert.Equal(t, 20, seen[len(seen)-1])
+	mu.Unlock()
+
+	// drained backends are forgotten once closed
+	w := s.backends.watch
+	assert.Nil(t, os.WriteFile(file, []byte("sqlite:\n  db: \":memory:\"\n"), 0600))
+	assert.Nil(t, s.Reload())
+	assert.Nil(t, os.WriteFile(file, []byte("sqlite:\n  db: \":memory:\"\n  max_open_conns: 20\n"), 0600))
+	assert.Nil(t, s.Reload())
+	assert.Eventually(t, func() bool {
+		w.subsMu.Lock()
+		defer w.subsMu.Unlock()
+		return len(w.drains) == 0
+	}, time.Second, time.Millisecond)
+}
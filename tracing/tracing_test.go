@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewProvider(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	tp, err := NewProvider(ctx, Config{ServiceName: "test", Exporter: ExporterStdout, Writer: &buf})
+	if assert.Nil(t, err) {
+		_, span := tp.Tracer("test").Start(ctx, "op")
+		span.End()
+		assert.Nil(t, tp.Shutdown(ctx))
+		assert.Contains(t, buf.String(), `"Name":"op"`)
+		assert.Contains(t, buf.String(), `"Value":"test"`)
+	}
+
+	// nothing is exported
+	tp, err = NewProvider(ctx, Config{Exporter: ExporterNone, SampleRatio: 2})
+	if assert.Nil(t, err) {
+		_, span := tp.Tracer("test").Start(ctx, "op")
+		assert.True(t, span.SpanContext().IsSampled())
+		span.End()
+		assert.Nil(t, tp.Shutdown(ctx))
+	}
+
+	_, err = NewProvider(ctx, Config{Exporter: "zipkin"})
+	assert.EqualError(t, err, `tracing: unknown exporter "zipkin"`)
+}
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	defer func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	}()
+
+	var buf bytes.Buffer
+	shutdown, err := Setup(ctx, Config{Exporter: ExporterStdout, Writer: &buf})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	ctx, span := otel.Tracer("test").Start(ctx, "op")
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	span.End()
+
+	assert.Nil(t, shutdown(context.Background()))
+	assert.Contains(t, buf.String(), `"Name":"op"`)
+
+	sc := trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), carrier))
+	assert.Equal(t, span.SpanContext().TraceID(), sc.TraceID())
+}